@@ -64,22 +64,22 @@ func handleAidText(ctx context.Context, input []byte, m richcontent.MatchIndices
 	}
 
 	aidString := string(m.ByteSliceOf(input, 1))
-	return aidAndBrdnameToArticle(bn.Boardname(), aidString)
+	return aidAndBrdnameToArticle(ctx, bn.Boardname(), aidString)
 }
 
 func handleAidBoardText(ctx context.Context, input []byte, m richcontent.MatchIndices) (string, error) {
 	aidString := string(m.ByteSliceOf(input, 1))
 	brdname := string(m.ByteSliceOf(input, 2))
-	return aidAndBrdnameToArticle(brdname, aidString)
+	return aidAndBrdnameToArticle(ctx, brdname, aidString)
 }
 
 func handleBoardAidText(ctx context.Context, input []byte, m richcontent.MatchIndices) (string, error) {
 	brdname := string(m.ByteSliceOf(input, 1))
 	aidString := string(m.ByteSliceOf(input, 2))
-	return aidAndBrdnameToArticle(brdname, aidString)
+	return aidAndBrdnameToArticle(ctx, brdname, aidString)
 }
 
-func aidAndBrdnameToArticle(brdname, aidString string) (string, error) {
+func aidAndBrdnameToArticle(ctx context.Context, brdname, aidString string) (string, error) {
 	aid, err := pttbbs.ParseAid(aidString)
 	if err != nil {
 		return "", nil // Silently fail
@@ -89,5 +89,11 @@ func aidAndBrdnameToArticle(brdname, aidString string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if absolute, _ := ctx.Value(CtxKeyAbsoluteLinks).(bool); absolute {
+		if config.SitePrefix == "" {
+			return "", nil // No way to link to the site
+		}
+		return config.SitePrefix + u.String(), nil
+	}
 	return u.String(), nil
 }
@@ -0,0 +1,192 @@
+package article
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	inlineContainerStyle = `white-space:pre-wrap;font-family:monospace;color:#c0c0c0;background-color:#000`
+)
+
+var (
+	// Colors of the 8 ANSI colors, normal then highlighted.
+	inlineFgColors = [2][8]string{
+		{`#000`, `#800`, `#080`, `#880`, `#008`, `#808`, `#088`, `#c0c0c0`},
+		{`#808080`, `#f00`, `#0f0`, `#ff0`, `#00f`, `#f0f`, `#0ff`, `#fff`},
+	}
+	inlineBgColors = inlineFgColors[0]
+
+	inlineClassStyles = map[string]string{
+		ClassArticleMetaTag:   `color:#008;background-color:#c0c0c0`,
+		ClassArticleMetaValue: `color:#c0c0c0;background-color:#008`,
+	}
+
+	// Elements dropped along with their content.
+	inlineDroppedElements = map[string]bool{
+		"iframe": true,
+		"object": true,
+		"embed":  true,
+		"script": true,
+		"style":  true,
+	}
+)
+
+// InlineStyledHTML converts the HTML produced by Render into a
+// self-contained fragment for readers without our stylesheet, e.g. feed
+// readers. Classes are replaced with inline styles, and only spans, divs,
+// links and images are kept. The output is capped at around maxSize bytes,
+// in which case truncated is true and open elements are closed properly.
+func InlineStyledHTML(src []byte, maxSize int) (out []byte, truncated bool) {
+	var buf bytes.Buffer
+	buf.WriteString(`<div style="` + inlineContainerStyle + `">`)
+
+	var open []string
+	dropDepth := 0
+	z := xhtml.NewTokenizer(bytes.NewReader(src))
+loop:
+	for {
+		if maxSize > 0 && buf.Len() >= maxSize {
+			truncated = true
+			break
+		}
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			break loop
+		case xhtml.TextToken:
+			if dropDepth == 0 {
+				buf.WriteString(html.EscapeString(string(z.Text())))
+			}
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			tok := z.Token()
+			if inlineDroppedElements[tok.Data] {
+				if tt == xhtml.StartTagToken {
+					dropDepth++
+				}
+				continue
+			}
+			if dropDepth > 0 {
+				continue
+			}
+			if writeInlineStartTag(&buf, &tok) && tt == xhtml.StartTagToken && tok.Data != "img" {
+				open = append(open, tok.Data)
+			}
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if inlineDroppedElements[tag] {
+				if dropDepth > 0 {
+					dropDepth--
+				}
+				continue
+			}
+			if dropDepth > 0 {
+				continue
+			}
+			if n := len(open); n > 0 && open[n-1] == tag {
+				buf.WriteString(`</` + tag + `>`)
+				open = open[:n-1]
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		buf.WriteString(`</` + open[i] + `>`)
+	}
+	buf.WriteString(`</div>`)
+	return buf.Bytes(), truncated
+}
+
+func writeInlineStartTag(buf *bytes.Buffer, tok *xhtml.Token) bool {
+	switch tok.Data {
+	case "span", "div":
+		buf.WriteString(`<` + tok.Data)
+		if style := inlineStyleOf(attrOf(tok, "class")); style != "" {
+			buf.WriteString(` style="` + style + `"`)
+		}
+		buf.WriteString(`>`)
+	case "a":
+		href, ok := safeInlineURL(attrOf(tok, "href"))
+		if !ok {
+			return false
+		}
+		buf.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow">`)
+	case "img":
+		src, ok := safeInlineURL(attrOf(tok, "src"))
+		if !ok {
+			return false
+		}
+		buf.WriteString(`<img src="` + html.EscapeString(src) + `" alt="" />`)
+	case "br":
+		buf.WriteString(`<br />`)
+		return false
+	default:
+		return false
+	}
+	return true
+}
+
+func attrOf(tok *xhtml.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func safeInlineURL(u string) (string, bool) {
+	switch {
+	case strings.HasPrefix(u, "//"):
+		return "https:" + u, true
+	case strings.HasPrefix(u, "http://"), strings.HasPrefix(u, "https://"):
+		return u, true
+	}
+	return "", false
+}
+
+func inlineStyleOf(class string) string {
+	fg, bg, hl := -1, -1, false
+	var styles []string
+	for _, c := range strings.Fields(class) {
+		switch {
+		case c == ClassHighlight:
+			hl = true
+		case strings.HasPrefix(c, ClassFgPrefix) && len(c) == len(ClassFgPrefix)+1:
+			fg = colorIndex(c[len(ClassFgPrefix):])
+		case strings.HasPrefix(c, ClassBgPrefix) && len(c) == len(ClassBgPrefix)+1:
+			bg = colorIndex(c[len(ClassBgPrefix):])
+		default:
+			if s, ok := inlineClassStyles[c]; ok {
+				styles = append(styles, s)
+			}
+		}
+	}
+	if hl && fg < 0 {
+		fg = DefaultFg
+	}
+	if fg >= 0 {
+		shade := 0
+		if hl {
+			shade = 1
+		}
+		styles = append(styles, `color:`+inlineFgColors[shade][fg])
+	}
+	if bg >= 0 {
+		styles = append(styles, `background-color:`+inlineBgColors[bg])
+	}
+	return strings.Join(styles, `;`)
+}
+
+func colorIndex(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 7 {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,46 @@
+package article
+
+import "testing"
+
+func TestInlineStyledHTML(t *testing.T) {
+	const (
+		open  = `<div style="` + inlineContainerStyle + `">`
+		close = `</div>`
+	)
+	tests := []struct {
+		desc          string
+		input         string
+		maxSize       int
+		wantHTML      string
+		wantTruncated bool
+	}{
+		{
+			desc:     "colors",
+			input:    `<span class="f1 hl">a</span><span class="hl b4">b</span>`,
+			wantHTML: open + `<span style="color:#f00">a</span><span style="color:#fff;background-color:#008">b</span>` + close,
+		},
+		{
+			desc:     "drop embeds and unsafe links",
+			input:    `<div class="richcontent"><iframe src="//example.com/">x</iframe></div><a href="javascript:alert(1)">y</a>`,
+			wantHTML: open + `<div></div>y` + close,
+		},
+		{
+			desc:     "keep links and images",
+			input:    `<a href="http://example.com/" target="_blank">&lt;x&gt;</a><img src="//example.com/a.png" alt="" loading="lazy" />`,
+			wantHTML: open + `<a href="http://example.com/" rel="nofollow">&lt;x&gt;</a><img src="https://example.com/a.png" alt="" />` + close,
+		},
+		{
+			desc:          "truncate",
+			input:         `<span class="f2">aaaa</span><span class="f3">bbbb</span>`,
+			maxSize:       len(open + `<span style="color:#080">a`),
+			wantHTML:      open + `<span style="color:#080">aaaa</span>` + close,
+			wantTruncated: true,
+		},
+	}
+	for _, test := range tests {
+		got, truncated := InlineStyledHTML([]byte(test.input), test.maxSize)
+		if string(got) != test.wantHTML || truncated != test.wantTruncated {
+			t.Errorf("%v: InlineStyledHTML(test.input, %v):\ngot  = %v, %v\nwant = %v, %v", test.desc, test.maxSize, string(got), truncated, test.wantHTML, test.wantTruncated)
+		}
+	}
+}
@@ -2,8 +2,9 @@ package article
 
 import (
 	"bytes"
+	"io"
 	"log"
-	"strings"
+	"sync"
 
 	"github.com/ptt/pttweb/ansi"
 	"github.com/ptt/pttweb/pttbbs"
@@ -14,6 +15,10 @@ import (
 
 const (
 	kPreviewContentLines = 5
+
+	// kMaxEmbedLookups is the number of remote lookups of embeds, e.g.
+	// oEmbed, a render may wait for.
+	kMaxEmbedLookups = 2
 )
 
 type RenderOption func(*renderer)
@@ -36,35 +41,147 @@ func WithDisableArticleHeader() RenderOption {
 	}
 }
 
+// WithDisableEmbeds keeps links, but not rich contents like images and
+// videos after them.
+func WithDisableEmbeds() RenderOption {
+	return func(r *renderer) {
+		r.disableEmbeds = true
+	}
+}
+
+// WithDisableLinks renders urls and article ids as plain text, without rich
+// contents either.
+func WithDisableLinks() RenderOption {
+	return func(r *renderer) {
+		r.disableLinks = true
+	}
+}
+
+// WithDisablePushDetection renders push lines as normal lines.
+func WithDisablePushDetection() RenderOption {
+	return func(r *renderer) {
+		r.disablePushDetection = true
+	}
+}
+
+// WithWrapColumns breaks lines wider than cols, counting non-ASCII
+// characters as 2 columns as terminals do. Zero disables wrapping.
+func WithWrapColumns(cols int) RenderOption {
+	return func(r *renderer) {
+		r.wrapColumns = cols
+	}
+}
+
 type RenderedArticle interface {
 	ParsedTitle() string
 	PreviewContent() string
 	HTML() []byte
+	PushStats() PushStats
+}
+
+// PushStats counts push lines by their kinds.
+type PushStats struct {
+	Recommend int
+	Boo       int
+	Arrow     int
+}
+
+func (s *PushStats) Add(t PushStats) {
+	s.Recommend += t.Recommend
+	s.Boo += t.Boo
+	s.Arrow += t.Arrow
+}
+
+func (s *PushStats) count(tag []byte) {
+	switch string(tag) {
+	case pttbbs.ArticlePushPrefixStrings[0]:
+		s.Recommend++
+	case pttbbs.ArticlePushPrefixStrings[1]:
+		s.Boo++
+	case pttbbs.ArticlePushPrefixStrings[2]:
+		s.Arrow++
+	}
 }
 
 func Render(opts ...RenderOption) (RenderedArticle, error) {
-	r := newRenderer()
+	return render(nil, opts)
+}
+
+// RenderTo is like Render, but writes HTML to w as each line is rendered,
+// instead of holding all of it. HTML of the result is empty. Rendering stops
+// at the first error writing to w.
+func RenderTo(w io.Writer, opts ...RenderOption) (RenderedArticle, error) {
+	return render(w, opts)
+}
+
+// Renderers are reused, except those having grown too large buffers.
+const kMaxPooledBufferSize = 256 * 1024
+
+var rendererPool = sync.Pool{
+	New: func() interface{} {
+		return newRenderer()
+	},
+}
+
+func render(w io.Writer, opts []RenderOption) (RenderedArticle, error) {
+	r := rendererPool.Get().(*renderer)
+	defer func() {
+		r.init()
+		if r.buf.Cap() <= kMaxPooledBufferSize {
+			rendererPool.Put(r)
+		}
+	}()
+
 	for _, opt := range opts {
 		opt(r)
 	}
+	r.out = w
 	if err := r.Render(); err != nil {
 		return nil, err
 	}
-	return r, nil
+	return r.result(), nil
 }
 
+// renderedArticle is the result detached from the renderer.
+type renderedArticle struct {
+	title          string
+	previewContent string
+	html           []byte
+	pushStats      PushStats
+}
+
+func (a *renderedArticle) ParsedTitle() string    { return a.title }
+func (a *renderedArticle) PreviewContent() string { return a.previewContent }
+func (a *renderedArticle) HTML() []byte           { return a.html }
+func (a *renderedArticle) PushStats() PushStats   { return a.pushStats }
+
 type renderer struct {
 	// Options.
 	content              []byte
 	disableArticleHeader bool
+	disableEmbeds        bool
+	disableLinks         bool
+	disablePushDetection bool
+	wrapColumns          int
 	ctx                  context.Context
 
 	// Internal states.
 	buf    bytes.Buffer
 	lineNo int
+	column int
+
+	// wraps are where lines break for wrapColumns, in order. Lines are
+	// broken only in output, so they are still matched as a whole.
+	wraps     []wrapPoint
+	wrapIndex int
+
+	// If set, buf is written to out and reset at end of lines.
+	out    io.Writer
+	outErr error
 
 	mapper    *IndexMapper
 	lineBuf   bytes.Buffer
+	segBuf    bytes.Buffer
 	lineSegs  []Segment
 	segIndex  int
 	segOffset int
@@ -76,13 +193,14 @@ type renderer struct {
 
 	title string
 
-	previewContent   string
+	previewContent   bytes.Buffer
 	previewLineCount int
+
+	pushStats PushStats
 }
 
 func newRenderer() *renderer {
 	ar := &renderer{
-		ctx:      context.TODO(),
 		mapper:   NewIndexMapper(2),
 		lineSegs: make([]Segment, 0, 8),
 	}
@@ -91,11 +209,26 @@ func newRenderer() *renderer {
 }
 
 func (r *renderer) init() {
+	// Options.
+	r.content = nil
+	r.disableArticleHeader = false
+	r.disableEmbeds = false
+	r.disableLinks = false
+	r.disablePushDetection = false
+	r.wrapColumns = 0
+	r.ctx = context.TODO()
+
 	r.buf.Reset()
 	r.lineNo = 1
+	r.column = 0
+	r.wraps = r.wraps[0:0]
+	r.wrapIndex = 0
+	r.out = nil
+	r.outErr = nil
 
 	r.mapper.Reset()
 	r.lineBuf.Reset()
+	r.segBuf.Reset()
 	r.lineSegs = r.lineSegs[0:0]
 	r.segIndex = 0
 	r.segOffset = 0
@@ -107,23 +240,26 @@ func (r *renderer) init() {
 
 	r.title = ""
 
-	r.previewContent = ""
+	r.previewContent.Reset()
 	r.previewLineCount = 0
-}
-
-func (r *renderer) ParsedTitle() string {
-	return r.title
-}
 
-func (r *renderer) PreviewContent() string {
-	return r.previewContent
+	r.pushStats = PushStats{}
 }
 
-func (r *renderer) HTML() []byte {
-	return r.buf.Bytes()
+func (r *renderer) result() *renderedArticle {
+	a := &renderedArticle{
+		title:          r.title,
+		previewContent: r.previewContent.String(),
+		pushStats:      r.pushStats,
+	}
+	if r.buf.Len() > 0 {
+		a.html = append([]byte(nil), r.buf.Bytes()...)
+	}
+	return a
 }
 
 func (r *renderer) Render() error {
+	r.ctx = richcontent.WithLookupBudget(r.ctx, kMaxEmbedLookups)
 	converter := &ansi.AnsiParser{
 		Rune:   r.oneRune,
 		Escape: r.escape,
@@ -135,7 +271,16 @@ func (r *renderer) Render() error {
 	if r.lineBuf.Len() > 0 {
 		r.endOfLine()
 	}
-	return nil
+	return r.outErr
+}
+
+// flush writes rendered lines to out, if any.
+func (r *renderer) flush() {
+	if r.out == nil || r.outErr != nil {
+		return
+	}
+	_, r.outErr = r.out.Write(r.buf.Bytes())
+	r.buf.Reset()
 }
 
 func (r *renderer) currSeg() *Segment {
@@ -157,9 +302,10 @@ func (r *renderer) startSegment() {
 		r.endSegment()
 	}
 	r.lineSegs = append(r.lineSegs, Segment{
+		Begin:     r.segBuf.Len(),
+		End:       r.segBuf.Len(),
 		Tag:       "span",
 		TermState: r.terminalState,
-		Buffer:    &bytes.Buffer{},
 	})
 	r.segClosed = false
 }
@@ -173,10 +319,32 @@ func (r *renderer) endSegment() {
 	r.segClosed = true
 }
 
+// wrapPoint is an offset in a segment of a line to break before.
+type wrapPoint struct {
+	seg, off int
+}
+
+func (r *renderer) mayBeMetaLine() bool {
+	return !r.disableArticleHeader && r.acceptMetaLines && r.lineNo < 5
+}
+
 func (r *renderer) oneRune(ru rune) {
+	if r.outErr != nil {
+		return
+	}
 	seg := r.currSeg()
+	if r.wrapColumns > 0 && ru != '\n' {
+		w := runeColumns(ru)
+		if r.column > 0 && r.column+w > r.wrapColumns {
+			r.wraps = append(r.wraps, wrapPoint{len(r.lineSegs) - 1, seg.Len()})
+			r.column = 0
+		}
+		r.column += w
+	}
 	r.mapper.Record(r.lineBuf.Len(), len(r.lineSegs)-1, seg.Len())
-	fastWriteHtmlEscapedRune(seg.Buffer, ru)
+	// Only the last segment is open, so it always ends the buffer.
+	fastWriteHtmlEscapedRune(&r.segBuf, ru)
+	seg.End = r.segBuf.Len()
 	r.lineBuf.WriteRune(ru)
 
 	if ru == '\n' {
@@ -188,7 +356,7 @@ func (r *renderer) outputToSegment(i, off int) {
 	for ; r.segIndex < i; r.segIndex++ {
 		s := &r.lineSegs[r.segIndex]
 		r.maybeOpenCurrentSegment()
-		r.buf.Write(s.InnerBytes()[r.segOffset:])
+		r.writeSegment(s.Bytes(r.segBuf.Bytes()), r.segOffset, s.Len())
 		r.maybeCloseCurrentSegment()
 		// advance to next segment at offset 0.
 		r.segOffset = 0
@@ -196,11 +364,30 @@ func (r *renderer) outputToSegment(i, off int) {
 	if off > 0 {
 		s := &r.lineSegs[r.segIndex]
 		r.maybeOpenCurrentSegment()
-		r.buf.Write(s.InnerBytes()[r.segOffset:off])
+		r.writeSegment(s.Bytes(r.segBuf.Bytes()), r.segOffset, off)
 		r.segOffset = off
 	}
 }
 
+// writeSegment writes b[from:to] of the current segment, breaking lines at
+// wrap points within.
+func (r *renderer) writeSegment(b []byte, from, to int) {
+	for ; r.wrapIndex < len(r.wraps); r.wrapIndex++ {
+		w := r.wraps[r.wrapIndex]
+		if w.seg > r.segIndex || w.seg == r.segIndex && w.off >= to {
+			break
+		}
+		// Skip those trimmed or left behind.
+		if w.seg < r.segIndex || w.off < from {
+			continue
+		}
+		r.buf.Write(b[from:w.off])
+		r.buf.WriteByte('\n')
+		from = w.off
+	}
+	r.buf.Write(b[from:to])
+}
+
 func (r *renderer) skipToSegment(i, off int) {
 	r.maybeCloseCurrentSegment()
 	r.segIndex = i
@@ -249,7 +436,7 @@ func (r *renderer) endOfLine() {
 	line := r.lineBuf.Bytes()
 	parsed := false
 
-	if !r.disableArticleHeader && r.acceptMetaLines && r.lineNo < 5 {
+	if r.mayBeMetaLine() {
 		if r.lineNo == 1 && r.matchFirstLineAndOutput(line) {
 			parsed = true
 		} else if tag, val, ok := pttbbs.ParseArticleMetaLine(line); ok {
@@ -278,10 +465,10 @@ func (r *renderer) endOfLine() {
 
 		// Collect non-empty lines as preview starting at first main
 		// content line.
-		isEmpty := len(strings.TrimSpace(string(line))) == 0
+		isEmpty := len(bytes.TrimSpace(line)) == 0
 		canCollect := !isEmpty && (r.previewLineCount == 0 && isMainContent || r.previewLineCount > 0)
 		if canCollect && r.previewLineCount < kPreviewContentLines {
-			r.previewContent += string(line)
+			r.previewContent.Write(line)
 			r.previewLineCount++
 		}
 		r.processNormalContentLine(line)
@@ -290,31 +477,42 @@ func (r *renderer) endOfLine() {
 	// Reset and update variables
 	r.mapper.Reset()
 	r.lineBuf.Reset()
+	r.segBuf.Reset()
 	r.lineSegs = r.lineSegs[0:0]
 	r.segIndex = 0
 	r.segOffset = 0
 	r.segClosed = true
 	r.lineNo++
+	r.column = 0
+	r.wraps = r.wraps[0:0]
+	r.wrapIndex = 0
+
+	r.flush()
 }
 
 func (r *renderer) processNormalContentLine(line []byte) {
 	// Detect push line
 	isPush := false
-	if matchPushLine(r.lineSegs) {
+	if !r.disablePushDetection && matchPushLine(r.lineSegs, r.segBuf.Bytes()) {
 		r.lineSegs[0].ExtraFlags |= PushTag
 		r.lineSegs[1].ExtraFlags |= PushUserId
 		r.lineSegs[2].ExtraFlags |= PushContent
 		r.lineSegs[3].ExtraFlags |= PushIpDateTime
+		r.pushStats.count(r.lineSegs[0].Bytes(r.segBuf.Bytes()))
 		// Remove trailing spaces
-		r.lineSegs[2].TrimRight(" ")
+		r.lineSegs[2].TrimRight(r.segBuf.Bytes(), " ")
 		r.buf.WriteString(`<div class="` + ClassPushDiv + `">`)
 		isPush = true
 	}
 
-	rcs, err := richcontent.Find(r.ctx, line)
-	if err != nil {
-		rcs = nil
-		log.Println("warning: rendering article: richcontent.Find:", err)
+	var rcs []richcontent.RichContent
+	if !r.disableLinks {
+		var err error
+		rcs, err = richcontent.Find(r.ctx, line)
+		if err != nil {
+			rcs = nil
+			log.Println("warning: rendering article: richcontent.Find:", err)
+		}
 	}
 
 	for _, rc := range rcs {
@@ -346,6 +544,9 @@ func (r *renderer) processNormalContentLine(line []byte) {
 	}
 
 	// Append rich contents to next line.
+	if r.disableEmbeds {
+		return
+	}
 	for _, rc := range rcs {
 		for _, comp := range rc.Components() {
 			r.buf.WriteString(`<div class="richcontent">` + comp.HTML() + `</div>`)
@@ -1,6 +1,12 @@
 package article
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
 
 func TestRender(t *testing.T) {
 	tests := []struct {
@@ -38,3 +44,206 @@ func TestRender(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderOptions(t *testing.T) {
+	push := "\033[1;37m推 \033[33mfoo\033[m\033[33m: bar\033[m 10/01 00:00"
+	tests := []struct {
+		desc     string
+		input    string
+		opts     []RenderOption
+		wantHTML string
+	}{
+		{
+			desc:     "disable links",
+			input:    "see http://example.com/",
+			opts:     []RenderOption{WithDisableLinks()},
+			wantHTML: `see http://example.com/`,
+		},
+		{
+			desc:     "disable embeds",
+			input:    "http://i.imgur.com/abcdefg.jpg",
+			opts:     []RenderOption{WithDisableEmbeds()},
+			wantHTML: `<a href="http://i.imgur.com/abcdefg.jpg" target="_blank" rel="nofollow">http://i.imgur.com/abcdefg.jpg</a>`,
+		},
+		{
+			desc:     "disable push detection",
+			input:    push,
+			opts:     []RenderOption{WithDisablePushDetection()},
+			wantHTML: `<span class="hl">推 </span><span class="f3 hl">foo</span><span class="f3">: bar</span> 10/01 00:00`,
+		},
+		{
+			desc:     "wrap columns",
+			input:    "abcde中文\n12",
+			opts:     []RenderOption{WithWrapColumns(6)},
+			wantHTML: "abcde\n中文\n12",
+		},
+		{
+			desc:     "wrap push line",
+			input:    "\033[1;37m推 \033[33mfoo\033[m\033[33m: bar baz\033[m 10/01 00:00",
+			opts:     []RenderOption{WithWrapColumns(12)},
+			wantHTML: `<div class="push"><span class="hl push-tag">推 </span><span class="f3 hl push-userid">foo</span><span class="f3 push-content">: bar ` + "\n" + `baz</span><span class="push-ipdatetime"> 10/01 00` + "\n" + `:00</span></div>`,
+		},
+		{
+			desc:     "wrap url",
+			input:    "see http://example.com/abcdef ok",
+			opts:     []RenderOption{WithWrapColumns(12)},
+			wantHTML: `see <a href="http://example.com/abcdef" target="_blank" rel="nofollow">http://e` + "\n" + `xample.com/a` + "\n" + `bcdef</a> ok`,
+		},
+	}
+	for _, test := range tests {
+		opts := append([]RenderOption{WithContent([]byte(test.input)), WithDisableArticleHeader()}, test.opts...)
+		ra, err := Render(opts...)
+		if err != nil {
+			t.Errorf("%v: Render() = _, %v", test.desc, err)
+			continue
+		}
+		if got, want := string(ra.HTML()), test.wantHTML; got != want {
+			t.Errorf("%v: ra.HTML():\ngot  = %v\nwant = %v", test.desc, got, want)
+		}
+	}
+}
+
+const testArticleHeader = "作者: foo (bar) 看板: Test\n標題: [測試] hello\n時間: Sun Oct  1 00:00:00 2023\n\n"
+
+func TestRenderTo(t *testing.T) {
+	input := []byte(testArticleHeader + "\033[1;31mred\033[m http://example.com/\n" +
+		"\033[1;37m推 \033[33mfoo\033[m\033[33m: bar                    \033[m 10/01 00:00\n" +
+		"no newline at end")
+	ra, err := Render(WithContent(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	rs, err := RenderTo(&buf, WithContent(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), string(ra.HTML()); got != want {
+		t.Errorf("RenderTo() wrote:\ngot  = %v\nwant = %v", got, want)
+	}
+	if len(rs.HTML()) != 0 {
+		t.Errorf("rs.HTML() = %q; want empty", rs.HTML())
+	}
+	if got, want := rs.ParsedTitle(), ra.ParsedTitle(); got != want {
+		t.Errorf("rs.ParsedTitle() = %q; want %q", got, want)
+	}
+	if got, want := rs.PushStats(), ra.PushStats(); got != want {
+		t.Errorf("rs.PushStats() = %+v; want %+v", got, want)
+	}
+}
+
+func TestRenderWrapHeader(t *testing.T) {
+	ra, err := Render(WithContent([]byte(testArticleHeader+"abcdefghij\n")), WithWrapColumns(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ra.ParsedTitle(), "[測試] hello"; got != want {
+		t.Errorf("ra.ParsedTitle() = %q; want %q", got, want)
+	}
+	if got, want := string(ra.HTML()), "abcdefgh\nij\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("ra.HTML() = %q; want suffix %q", got, want)
+	}
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write failed")
+}
+
+func TestRenderToWriteError(t *testing.T) {
+	w := new(failingWriter)
+	if _, err := RenderTo(w, WithContent([]byte("a\nb\nc\n"))); err == nil {
+		t.Error("RenderTo() = _, nil; want error")
+	}
+	if w.writes != 1 {
+		t.Errorf("writes = %v; want 1", w.writes)
+	}
+}
+
+// repeatLines makes an article of about size bytes by cycling lines.
+func repeatLines(size int, lines ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(testArticleHeader)
+	for i := 0; buf.Len() < size; i++ {
+		buf.WriteString(lines[i%len(lines)])
+	}
+	return buf.Bytes()
+}
+
+// benchCorpus are articles of typical shapes.
+var benchCorpus = []struct {
+	name    string
+	content []byte
+}{
+	{
+		name:    "plain",
+		content: repeatLines(8<<10, "這是一篇普通的文章，只有一般的文字。 plain text\n", "\n"),
+	},
+	{
+		// Color changes every few characters.
+		name: "ascii-art",
+		content: repeatLines(32<<10,
+			"\033[1;31m▇▇\033[33m▇▇\033[32m▇▇\033[36m▇▇\033[34m▇▇\033[35m▇▇\033[m  \033[47;30m╭──╮\033[m\n",
+			"\033[41m  \033[42m  \033[43m  \033[44m  \033[45m  \033[46m  \033[m\033[1;37m★\033[m\n",
+		),
+	},
+	{
+		name: "push-heavy",
+		content: repeatLines(64<<10,
+			"\033[1;37m推 \033[33mfoo\033[m\033[33m: 推推推                  \033[m 10/01 00:00\n",
+			"\033[1;31m噓 \033[33mbar\033[m\033[33m: 噓                       \033[m 10/01 00:01\n",
+			"\033[1;31m→ \033[33mbaz\033[m\033[33m: 箭頭 & <tags>             \033[m 10/01 00:02\n",
+		),
+	},
+	{
+		name: "url-heavy",
+		content: repeatLines(32<<10,
+			"請參考 http://example.com/some/path?query=1 的說明\n",
+			"圖 https://i.imgur.com/abcdefg.jpg 還有 https://example.org/a.png\n",
+			": 引用 https://www.youtube.com/watch?v=dQw4w9WgXcQ\n",
+		),
+	},
+	{
+		name: "huge",
+		content: repeatLines(1<<20,
+			"\033[1;33m這是一行有顏色的文字\033[m 以及一般的文字 plain text\n",
+			"請參考 http://example.com/some/path?query=1 的說明\n",
+			"\033[1;37m推 \033[33mfoo\033[m\033[33m: 推推推                  \033[m 10/01 00:00\n",
+			": 引用的文字\n",
+			"\n",
+		),
+	},
+}
+
+func BenchmarkRender(b *testing.B) {
+	for _, c := range benchCorpus {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(c.content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Render(WithContent(c.content)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRenderTo(b *testing.B) {
+	for _, c := range benchCorpus {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(c.content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := RenderTo(ioutil.Discard, WithContent(c.content)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,9 +2,6 @@ package article
 
 import (
 	"bytes"
-	"io"
-	"strconv"
-	"strings"
 )
 
 type ExtraFlag int
@@ -17,8 +14,11 @@ const (
 	PushMaxVal // Dummy value
 )
 
+// Segment is a run of a line in the same style. Its HTML escaped content is
+// kept in the line buffer of the renderer, from Begin to End.
 type Segment struct {
-	*bytes.Buffer
+	Begin      int
+	End        int
 	Tag        string
 	ExtraFlags ExtraFlag
 	TermState  TerminalState
@@ -31,67 +31,65 @@ var extraFlagClasses = []string{
 	ClassPushIpDatetime,
 }
 
-func (s *Segment) WriteOpen(w io.Writer) (int, error) {
-	classes := make([]string, 0, 3)
+func (s *Segment) WriteOpen(buf *bytes.Buffer) {
+	n := 0
+	class := func(prefix string, c byte) {
+		if n == 0 {
+			buf.WriteByte('<')
+			buf.WriteString(s.Tag)
+			buf.WriteString(` class="`)
+		} else {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(prefix)
+		if c != 0 {
+			buf.WriteByte(c)
+		}
+		n++
+	}
 	if s.TermState.Fg() != 7 {
-		classes = append(classes, ClassFgPrefix+strconv.Itoa(s.TermState.Fg()))
+		class(ClassFgPrefix, byte('0'+s.TermState.Fg()))
 	}
 	if s.TermState.Bg() != 0 {
-		classes = append(classes, ClassBgPrefix+strconv.Itoa(s.TermState.Bg()))
+		class(ClassBgPrefix, byte('0'+s.TermState.Bg()))
 	}
 	if s.TermState.HasFlags(Highlighted) {
-		classes = append(classes, ClassHighlight)
+		class(ClassHighlight, 0)
 	}
 	for i, fl := 0, ExtraFlag(1); fl < PushMaxVal; i, fl = i+1, fl<<1 {
 		if s.HasExtraFlags(fl) {
-			classes = append(classes, extraFlagClasses[i])
+			class(extraFlagClasses[i], 0)
 		}
 	}
-	if len(classes) > 0 {
-		return w.Write([]byte(`<` + s.Tag + ` class="` + strings.Join(classes, ` `) + `">`))
+	if n > 0 {
+		buf.WriteString(`">`)
 	} else {
 		s.Tag = ""
 	}
-	return 0, nil
 }
 
 func (s *Segment) HasExtraFlags(fl ExtraFlag) bool {
 	return s.ExtraFlags&fl == fl
 }
 
-func (s *Segment) WriteInner(w io.Writer) (int, error) {
-	return w.Write(s.Bytes())
-}
-
-func (s *Segment) WriteClose(w io.Writer) (int, error) {
+func (s *Segment) WriteClose(buf *bytes.Buffer) {
 	if s.Tag != "" {
-		return w.Write([]byte(`</` + s.Tag + `>`))
+		buf.WriteString(`</`)
+		buf.WriteString(s.Tag)
+		buf.WriteByte('>')
 	}
-	return 0, nil
 }
 
-func (s *Segment) WriteTo(w io.Writer) (total int64, err error) {
-	wrote, err := s.WriteOpen(w)
-	total += int64(wrote)
-	if err != nil {
-		return
-	}
-
-	wrote, err = s.WriteInner(w)
-	total += int64(wrote)
-	if err != nil {
-		return
-	}
-
-	wrote, err = s.WriteClose(w)
-	total += int64(wrote)
-	return
+// Len returns the length of the escaped content.
+func (s *Segment) Len() int {
+	return s.End - s.Begin
 }
 
-func (s *Segment) InnerBytes() []byte {
-	return s.Bytes()
+// Bytes returns the escaped content in the line buffer.
+func (s *Segment) Bytes(line []byte) []byte {
+	return line[s.Begin:s.End]
 }
 
-func (s *Segment) TrimRight(cutset string) {
-	s.Truncate(len(bytes.TrimRight(s.Bytes(), cutset)))
+func (s *Segment) TrimRight(line []byte, cutset string) {
+	s.End = s.Begin + len(bytes.TrimRight(s.Bytes(line), cutset))
 }
@@ -38,6 +38,15 @@ func fastWriteHtmlEscaped(buf *bytes.Buffer, str string) {
 	}
 }
 
+// runeColumns returns the width of ru on terminals, where all double-byte
+// characters of Big5 are 2 columns.
+func runeColumns(ru rune) int {
+	if ru < 0x80 {
+		return 1
+	}
+	return 2
+}
+
 func makeExternalUrlLink(urlString string) (begin, end string) {
 	begin = `<a href="` + html.EscapeString(urlString) + `" target="_blank" rel="nofollow">`
 	end = `</a>`
@@ -50,16 +59,16 @@ func matchColor(t *TerminalState, fg, bg, flags int) bool {
 
 func matchAny(b []byte, patt []string) bool {
 	for _, p := range patt {
-		if bytes.Equal(b, []byte(p)) {
+		if string(b) == p {
 			return true
 		}
 	}
 	return false
 }
 
-func matchPushLine(segs []Segment) bool {
+func matchPushLine(segs []Segment, line []byte) bool {
 	return len(segs) == 4 &&
-		matchAny(segs[0].Bytes(), pttbbs.ArticlePushPrefixStrings) &&
+		matchAny(segs[0].Bytes(line), pttbbs.ArticlePushPrefixStrings) &&
 		(matchColor(&segs[0].TermState, 1, 0, Highlighted) ||
 			matchColor(&segs[0].TermState, 7, 0, Highlighted)) &&
 		matchColor(&segs[1].TermState, 3, 0, Highlighted) &&
@@ -0,0 +1,65 @@
+package atomfeed
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"golang.org/x/tools/blog/atom"
+)
+
+// AtomEncoder encodes feeds in Atom format.
+type AtomEncoder struct{}
+
+func (AtomEncoder) ContentType() string {
+	return "application/xml"
+}
+
+func (AtomEncoder) Encode(w io.Writer, feed *Feed, selfURL string) error {
+	var entries []*atom.Entry
+	for _, e := range feed.Entries {
+		entries = append(entries, &atom.Entry{
+			Author: &atom.Person{
+				Name: e.Author,
+			},
+			Title: e.Title,
+			ID:    e.ID,
+			Link: []atom.Link{{
+				Rel:  "alternate",
+				Type: "text/html",
+				Href: e.URL,
+			}},
+			Published: atom.Time(firstNonZero(e.Published, e.Updated, feed.Updated)),
+			Updated:   atom.Time(firstNonZero(e.Updated, e.Published, feed.Updated)),
+			Content: &atom.Text{
+				Type: "html",
+				Body: e.ContentHTML,
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(&atom.Feed{
+		Title: feed.Title,
+		ID:    feed.ID,
+		Link: []atom.Link{{
+			Rel:  "self",
+			Href: selfURL,
+		}},
+		Updated: atom.Time(feed.Updated),
+		Entry:   entries,
+	})
+}
+
+// firstNonZero returns the first of ts that is not zero. Atom requires times
+// of entries, so a known one stands in for those missing.
+func firstNonZero(ts ...time.Time) time.Time {
+	for _, t := range ts {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,45 @@
+package atomfeed
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/blog/atom"
+)
+
+func TestAtomEncoder(t *testing.T) {
+	out := encode(t, AtomEncoder{}, testFeed())
+	var feed atom.Feed
+	if err := xml.Unmarshal(out, &feed); err != nil {
+		t.Fatalf("xml.Unmarshal() = %v\n%s", err, out)
+	}
+	if feed.Title != testTitle {
+		t.Errorf("title = %q, want %q", feed.Title, testTitle)
+	}
+	if len(feed.Entry) != 1 {
+		t.Fatalf("got %d entries, want 1", len(feed.Entry))
+	}
+	e := feed.Entry[0]
+	if e.Content == nil || e.Content.Body != testContent {
+		t.Errorf("content = %+v, want %q", e.Content, testContent)
+	}
+	// The entry has no update time, and the publish time stands in for it.
+	if got, want := e.Updated, atom.Time(time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC)); got != want {
+		t.Errorf("updated = %q, want %q", got, want)
+	}
+}
+
+func TestAtomEncoderZeroTimes(t *testing.T) {
+	f := testFeed()
+	f.Entries[0].Published = time.Time{}
+	out := encode(t, AtomEncoder{}, f)
+	var feed atom.Feed
+	if err := xml.Unmarshal(out, &feed); err != nil {
+		t.Fatalf("xml.Unmarshal() = %v\n%s", err, out)
+	}
+	e := feed.Entry[0]
+	if want := atom.Time(f.Updated); e.Published != want || e.Updated != want {
+		t.Errorf("published, updated = %q, %q, want the feed time %q", e.Published, e.Updated, want)
+	}
+}
@@ -6,24 +6,45 @@ import (
 	"html/template"
 	"time"
 
+	"github.com/ptt/pttweb/article"
 	"github.com/ptt/pttweb/pttbbs"
-	"golang.org/x/tools/blog/atom"
 )
 
 type PostEntry struct {
 	Article pttbbs.Article
 	Snippet string
+
+	// BrdName is the board of the post. Defaults to the board of the feed.
+	BrdName string
+
+	// Content is the full rendered article. Snippet is used if it and
+	// ContentTail are empty.
+	Content          []byte
+	ContentTruncated bool
+	// ContentTail is the end of the article, if the middle is left out of
+	// Content, e.g. of long articles.
+	ContentTail []byte
+	PushStats   article.PushStats
 }
 
 type Converter struct {
 	FeedTitleTemplate *template.Template
 	LinkFeed          func(brdname string) (string, error)
+	LinkBoard         func(brdname string) (string, error)
 	LinkArticle       func(brdname, filename string) (string, error)
 }
 
-func (c *Converter) Convert(board pttbbs.Board, posts []*PostEntry) (*atom.Feed, error) {
+func (c *Converter) FeedTitle(board pttbbs.Board) (string, error) {
 	var title bytes.Buffer
 	if err := c.FeedTitleTemplate.Execute(&title, board); err != nil {
+		return "", err
+	}
+	return title.String(), nil
+}
+
+func (c *Converter) Convert(board pttbbs.Board, posts []*PostEntry) (*Feed, error) {
+	title, err := c.FeedTitle(board)
+	if err != nil {
 		return nil, err
 	}
 
@@ -32,10 +53,27 @@ func (c *Converter) Convert(board pttbbs.Board, posts []*PostEntry) (*atom.Feed,
 		return nil, err
 	}
 
-	var entries []*atom.Entry
+	var homeURL string
+	if c.LinkBoard != nil {
+		if homeURL, err = c.LinkBoard(board.BrdName); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.convert(title, feedURL, homeURL, board.BrdName, posts), nil
+}
+
+// ConvertGroup converts posts from multiple boards into a feed. BrdName of
+// each post must be set.
+func (c *Converter) ConvertGroup(title, feedURL, homeURL string, posts []*PostEntry) (*Feed, error) {
+	return c.convert(title, feedURL, homeURL, "", posts), nil
+}
+
+func (c *Converter) convert(title, feedURL, homeURL, brdname string, posts []*PostEntry) *Feed {
+	var entries []*Entry
 	// Reverse (time) order.
 	for i := len(posts) - 1; i >= 0; i-- {
-		entry, err := c.convertArticle(posts[i], board.BrdName)
+		entry, err := c.convertArticle(posts[i], brdname)
 		if err != nil {
 			// Ignore errors.
 			continue
@@ -43,46 +81,52 @@ func (c *Converter) Convert(board pttbbs.Board, posts []*PostEntry) (*atom.Feed,
 		entries = append(entries, entry)
 	}
 
-	return &atom.Feed{
-		Title: title.String(),
-		ID:    feedURL,
-		Link: []atom.Link{{
-			Rel:  "self",
-			Href: feedURL,
-		}},
-		Updated: atom.Time(firstArticleTimeOrNow(posts)),
-		Entry:   entries,
-	}, nil
+	return &Feed{
+		Title:   title,
+		ID:      feedURL,
+		HomeURL: homeURL,
+		Updated: firstArticleTimeOrNow(posts),
+		Entries: entries,
+	}
 }
 
-func (c *Converter) convertArticle(p *PostEntry, brdname string) (*atom.Entry, error) {
+func (c *Converter) convertArticle(p *PostEntry, brdname string) (*Entry, error) {
 	a := p.Article
+	if p.BrdName != "" {
+		brdname = p.BrdName
+	}
 	articleURL, err := c.LinkArticle(brdname, a.FileName)
 	if err != nil {
 		return nil, err
 	}
 	// Will use a zero time if unable to parse.
 	published, _ := pttbbs.ParseFileNameTime(a.FileName)
-	return &atom.Entry{
-		Author: &atom.Person{
-			Name: a.Owner,
-		},
-		Title: a.Title,
-		ID:    articleURL,
-		Link: []atom.Link{{
-			Rel:  "alternate",
-			Type: "text/html",
-			Href: articleURL,
-		}},
-		Published: atom.Time(published),
-		Updated:   atom.Time(a.Modified),
-		Content: &atom.Text{
-			Type: "html",
-			Body: fmt.Sprintf("<pre>%v</pre>", p.Snippet),
-		},
+	return &Entry{
+		ID:          articleURL,
+		URL:         articleURL,
+		Title:       a.Title,
+		Author:      a.Owner,
+		Published:   published,
+		Updated:     a.Modified,
+		ContentHTML: entryContentHTML(p, articleURL),
 	}, nil
 }
 
+func entryContentHTML(p *PostEntry, articleURL string) string {
+	if len(p.Content) == 0 && len(p.ContentTail) == 0 {
+		return fmt.Sprintf("<pre>%v</pre>", p.Snippet)
+	}
+	var b bytes.Buffer
+	b.Write(p.Content)
+	if p.ContentTruncated {
+		fmt.Fprintf(&b, `<p><a href="%v">...</a></p>`, template.HTMLEscapeString(articleURL))
+	}
+	b.Write(p.ContentTail)
+	s := p.PushStats
+	fmt.Fprintf(&b, "<p>推 %v 噓 %v → %v</p>", s.Recommend, s.Boo, s.Arrow)
+	return b.String()
+}
+
 func firstArticleTimeOrNow(posts []*PostEntry) time.Time {
 	for _, p := range posts {
 		if t, err := pttbbs.ParseFileNameTime(p.Article.FileName); err == nil {
@@ -0,0 +1,36 @@
+package atomfeed
+
+import (
+	"io"
+	"time"
+)
+
+// Feed is a format-independent feed. It is rendered by an Encoder.
+type Feed struct {
+	Title string
+	// ID is the permanent identifier of the feed.
+	ID string
+	// HomeURL is the page the feed is about. Optional.
+	HomeURL string
+	Updated time.Time
+	Entries []*Entry
+}
+
+type Entry struct {
+	ID          string
+	URL         string
+	Title       string
+	Author      string
+	Published   time.Time
+	Updated     time.Time
+	ContentHTML string
+}
+
+// Encoder writes a Feed in a specific format.
+type Encoder interface {
+	// ContentType returns the value of Content-Type header.
+	ContentType() string
+
+	// Encode writes feed to w. selfURL is where the encoded feed is served.
+	Encode(w io.Writer, feed *Feed, selfURL string) error
+}
@@ -0,0 +1,39 @@
+package atomfeed
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+const (
+	testTitle   = `<b>"R&D"</b>`
+	testContent = `<p>a &amp; b</p><img src="x.png" />`
+)
+
+func testFeed() *Feed {
+	return &Feed{
+		Title:   testTitle,
+		ID:      "tag:example.com,2023:Test",
+		HomeURL: "https://example.com/bbs/Test/index.html",
+		Updated: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		Entries: []*Entry{
+			{
+				ID:          "https://example.com/bbs/Test/M.1.A.2.html",
+				URL:         "https://example.com/bbs/Test/M.1.A.2.html",
+				Title:       testTitle,
+				Author:      "foo",
+				Published:   time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC),
+				ContentHTML: testContent,
+			},
+		},
+	}
+}
+
+func encode(t *testing.T, enc Encoder, feed *Feed) []byte {
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, feed, "https://example.com/feed"); err != nil {
+		t.Fatalf("%T.Encode() = %v", enc, err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,71 @@
+package atomfeed
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONFeedEncoder encodes feeds in JSON Feed 1.1 format.
+// See https://www.jsonfeed.org/version/1.1/
+type JSONFeedEncoder struct{}
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url,omitempty"`
+	FeedURL     string          `json:"feed_url,omitempty"`
+	Items       []*jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string            `json:"id"`
+	URL           string            `json:"url,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	ContentHTML   string            `json:"content_html"`
+	DatePublished string            `json:"date_published,omitempty"`
+	DateModified  string            `json:"date_modified,omitempty"`
+	Authors       []*jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+func (JSONFeedEncoder) ContentType() string {
+	return "application/feed+json"
+}
+
+func (JSONFeedEncoder) Encode(w io.Writer, feed *Feed, selfURL string) error {
+	items := make([]*jsonFeedItem, 0, len(feed.Entries))
+	for _, e := range feed.Entries {
+		item := &jsonFeedItem{
+			ID:            e.ID,
+			URL:           e.URL,
+			Title:         e.Title,
+			ContentHTML:   e.ContentHTML,
+			DatePublished: jsonFeedTime(e.Published),
+			DateModified:  jsonFeedTime(e.Updated),
+		}
+		if e.Author != "" {
+			item.Authors = []*jsonFeedAuthor{{Name: e.Author}}
+		}
+		items = append(items, item)
+	}
+	return json.NewEncoder(w).Encode(&jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       feed.Title,
+		HomePageURL: feed.HomeURL,
+		FeedURL:     selfURL,
+		Items:       items,
+	})
+}
+
+func jsonFeedTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
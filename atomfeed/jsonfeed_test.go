@@ -0,0 +1,46 @@
+package atomfeed
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJSONFeedEncoder(t *testing.T) {
+	out := encode(t, JSONFeedEncoder{}, testFeed())
+	var feed jsonFeed
+	if err := json.Unmarshal(out, &feed); err != nil {
+		t.Fatalf("json.Unmarshal() = %v\n%s", err, out)
+	}
+	if feed.Version != jsonFeedVersion || feed.Title != testTitle {
+		t.Errorf("unexpected feed: %+v", feed)
+	}
+	if feed.FeedURL != "https://example.com/feed" {
+		t.Errorf("feed_url = %q", feed.FeedURL)
+	}
+	if len(feed.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(feed.Items))
+	}
+	item := feed.Items[0]
+	if item.ContentHTML != testContent {
+		t.Errorf("content_html = %q, want %q", item.ContentHTML, testContent)
+	}
+	if item.DatePublished != "2023-10-01T10:00:00Z" {
+		t.Errorf("date_published = %q", item.DatePublished)
+	}
+	if len(item.Authors) != 1 || item.Authors[0].Name != "foo" {
+		t.Errorf("authors = %+v", item.Authors)
+	}
+}
+
+func TestJSONFeedEncoderZeroTimes(t *testing.T) {
+	f := testFeed()
+	f.Entries[0].Published = time.Time{}
+	out := encode(t, JSONFeedEncoder{}, f)
+	for _, s := range []string{"date_published", "date_modified", "0001"} {
+		if bytes.Contains(out, []byte(s)) {
+			t.Errorf("output contains %q:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package atomfeed
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// RSSEncoder encodes feeds in RSS 2.0 format.
+type RSSEncoder struct{}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate,omitempty"`
+	AtomLink      rssLink    `xml:"http://www.w3.org/2005/Atom link"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Creator     string  `xml:"http://purl.org/dc/elements/1.1/ creator,omitempty"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (RSSEncoder) ContentType() string {
+	return "application/rss+xml"
+}
+
+func (RSSEncoder) Encode(w io.Writer, feed *Feed, selfURL string) error {
+	link := feed.HomeURL
+	if link == "" {
+		link = selfURL
+	}
+	var items []*rssItem
+	for _, e := range feed.Entries {
+		items = append(items, &rssItem{
+			Title: e.Title,
+			Link:  e.URL,
+			GUID: rssGUID{
+				IsPermaLink: e.ID == e.URL,
+				Value:       e.ID,
+			},
+			Creator:     e.Author,
+			PubDate:     rssTime(e.Published),
+			Description: e.ContentHTML,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(&rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          link,
+			Description:   feed.Title,
+			LastBuildDate: rssTime(feed.Updated),
+			AtomLink: rssLink{
+				Href: selfURL,
+				Rel:  "self",
+				Type: RSSEncoder{}.ContentType(),
+			},
+			Items: items,
+		},
+	})
+}
+
+func rssTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC1123Z)
+}
@@ -0,0 +1,44 @@
+package atomfeed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestRSSEncoder(t *testing.T) {
+	out := encode(t, RSSEncoder{}, testFeed())
+	var feed rssFeed
+	if err := xml.Unmarshal(out, &feed); err != nil {
+		t.Fatalf("xml.Unmarshal() = %v\n%s", err, out)
+	}
+	if feed.Channel.Title != testTitle {
+		t.Errorf("title = %q, want %q", feed.Channel.Title, testTitle)
+	}
+	if got, want := feed.Channel.LastBuildDate, "Sun, 01 Oct 2023 12:00:00 +0000"; got != want {
+		t.Errorf("lastBuildDate = %q, want %q", got, want)
+	}
+	if len(feed.Channel.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(feed.Channel.Items))
+	}
+	item := feed.Channel.Items[0]
+	if item.Description != testContent {
+		t.Errorf("description = %q, want %q", item.Description, testContent)
+	}
+	if !item.GUID.IsPermaLink || item.Creator != "foo" {
+		t.Errorf("unexpected item: %+v", item)
+	}
+}
+
+func TestRSSEncoderZeroTimes(t *testing.T) {
+	f := testFeed()
+	f.Updated = time.Time{}
+	f.Entries[0].Published = time.Time{}
+	out := encode(t, RSSEncoder{}, f)
+	for _, s := range []string{"<pubDate>", "<lastBuildDate>", "0001"} {
+		if bytes.Contains(out, []byte(s)) {
+			t.Errorf("output contains %q:\n%s", s, out)
+		}
+	}
+}
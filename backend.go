@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ptt/pttweb/breaker"
+	"github.com/ptt/pttweb/gate"
+	"github.com/ptt/pttweb/pttbbs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// BackendQueueTimeout is how long calls may wait for a gate before
+	// failing with a server too busy page.
+	BackendQueueTimeout = 10 * time.Second
+)
+
+// Gates admitting calls to backends.
+var (
+	boarddGate *gate.Gate
+	searchGate *gate.Gate
+	mandGate   *gate.Gate
+)
+
+// enterGate waits for a slot of the gate. It fails fast with
+// ServerBusyError if too many are waiting already, or waited too long.
+func enterGate(ctx context.Context, g *gate.Gate, prio gate.Priority, backend string) (release func(), err error) {
+	rsv, ok := g.ReservePriority(prio)
+	if !ok {
+		return nil, NewServerBusyError(backend)
+	}
+	ctx, cancel := context.WithTimeout(ctx, BackendQueueTimeout)
+	defer cancel()
+	if err := rsv.WaitContext(ctx); err != nil {
+		rsv.Release()
+		if err == context.DeadlineExceeded {
+			return nil, NewServerBusyError(backend)
+		}
+		return nil, err
+	}
+	return rsv.Release, nil
+}
+
+// gatedPttbbs limits concurrent calls to a boardd.
+type gatedPttbbs struct {
+	p       pttbbs.Pttbbs
+	g       *gate.Gate
+	prio    gate.Priority
+	ctx     context.Context
+	backend string
+}
+
+func newGatedPttbbs(p pttbbs.Pttbbs, g *gate.Gate, backend string) pttbbs.Pttbbs {
+	return &gatedPttbbs{
+		p:       p,
+		g:       g,
+		prio:    gate.PriorityNormal,
+		ctx:     context.Background(),
+		backend: backend,
+	}
+}
+
+// withPriority returns p making calls of the priority, e.g. high for
+// interactive article views and low for feeds and crawlers.
+func withPriority(p pttbbs.Pttbbs, prio gate.Priority) pttbbs.Pttbbs {
+	gp, ok := p.(*gatedPttbbs)
+	if !ok {
+		return p
+	}
+	q := *gp
+	q.prio = prio
+	return &q
+}
+
+// withContext returns p whose calls stop waiting for the gate once ctx is
+// done, e.g. when the request is canceled. Calls generating shared cache
+// entries shouldn't use contexts of requests.
+func withContext(p pttbbs.Pttbbs, ctx context.Context) pttbbs.Pttbbs {
+	gp, ok := p.(*gatedPttbbs)
+	if !ok {
+		return p
+	}
+	q := *gp
+	q.ctx = ctx
+	return &q
+}
+
+func (p *gatedPttbbs) enter() (func(), error) {
+	return enterGate(p.ctx, p.g, p.prio, p.backend)
+}
+
+func (p *gatedPttbbs) GetBoards(refs ...pttbbs.BoardRef) ([]pttbbs.Board, error) {
+	release, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.p.GetBoards(refs...)
+}
+
+func (p *gatedPttbbs) GetArticleList(ref pttbbs.BoardRef, offset, length int) ([]pttbbs.Article, error) {
+	release, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.p.GetArticleList(ref, offset, length)
+}
+
+func (p *gatedPttbbs) GetBottomList(ref pttbbs.BoardRef) ([]pttbbs.Article, error) {
+	release, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.p.GetBottomList(ref)
+}
+
+func (p *gatedPttbbs) GetArticleSelect(ref pttbbs.BoardRef, meth pttbbs.SelectMethod, filename, cacheKey string, offset, maxlen int) (*pttbbs.ArticlePart, error) {
+	release, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.p.GetArticleSelect(ref, meth, filename, cacheKey, offset, maxlen)
+}
+
+func (p *gatedPttbbs) Hotboards() ([]pttbbs.Board, error) {
+	release, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.p.Hotboards()
+}
+
+func (p *gatedPttbbs) Search(ref pttbbs.BoardRef, preds []pttbbs.SearchPredicate, offset, length int) ([]pttbbs.Article, int, error) {
+	release, err := p.enter()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+	return p.p.Search(ref, preds, offset, length)
+}
+
+// gateUnaryInterceptor limits concurrent calls of a grpc client.
+func gateUnaryInterceptor(g *gate.Gate, backend string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		release, err := enterGate(ctx, g, gate.PriorityNormal, backend)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// mandBreaker fails calls to mand fast when it has been failing.
+var mandBreaker *breaker.Breaker
+
+// isBackendDown reports whether err of a grpc call indicates the backend is
+// down, rather than a bad request.
+func isBackendDown(err error) bool {
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// breakerUnaryInterceptor bounds calls of a grpc client by timeout, and fails
+// them fast by b when the backend is down. Failed calls return errors made by
+// unavailable.
+func breakerUnaryInterceptor(b *breaker.Breaker, timeout time.Duration, unavailable func(error) error) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := b.Allow()
+		if err != nil {
+			return unavailable(err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		if isBackendDown(err) {
+			done(false)
+			return unavailable(err)
+		}
+		done(true)
+		return err
+	}
+}
+
+func handleGateStats(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]gate.Stats{
+		"boardd":    boarddGate.Stats(),
+		"search":    searchGate.Stats(),
+		"mand":      mandGate.Stats(),
+		"memcached": cacheMgr.GateStats(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,119 @@
+// Package breaker provides a circuit breaker to fail fast when a backend is
+// down.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker open")
+
+type State int
+
+const (
+	// Closed lets calls through.
+	Closed State = iota
+	// Open rejects calls until the cooldown passes.
+	Open
+	// HalfOpen lets one trial call through to probe the backend.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker opens after threshold consecutive failures, and stays open for
+// cooldown before trying again.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow returns ErrOpen if calls should fail fast. Otherwise, done must be
+// called with whether the call succeeded.
+func (b *Breaker) Allow() (done func(success bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state() {
+	case Open:
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.trial {
+			return nil, ErrOpen
+		}
+		b.trial = true
+		return b.doneTrial, nil
+	}
+	return b.done, nil
+}
+
+func (b *Breaker) done(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+func (b *Breaker) doneTrial(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.openUntil = b.now().Add(b.cooldown)
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+// state must be called with mu held.
+func (b *Breaker) state() State {
+	if b.failures < b.threshold {
+		return Closed
+	}
+	if b.now().Before(b.openUntil) {
+		return Open
+	}
+	return HalfOpen
+}
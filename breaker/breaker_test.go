@@ -0,0 +1,65 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := New(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	call := func(success bool) error {
+		done, err := b.Allow()
+		if err != nil {
+			return err
+		}
+		done(success)
+		return nil
+	}
+
+	// Failures below threshold and successes in between keep it closed.
+	call(false)
+	call(true)
+	call(false)
+	if got := b.State(); got != Closed {
+		t.Fatalf("State() = %v, want %v", got, Closed)
+	}
+
+	call(false)
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v, want %v", got, Open)
+	}
+	if err := call(true); err != ErrOpen {
+		t.Errorf("call when open = %v, want %v", err, ErrOpen)
+	}
+
+	// Only one trial is allowed when half-open.
+	now = now.Add(time.Minute)
+	if got := b.State(); got != HalfOpen {
+		t.Fatalf("State() = %v, want %v", got, HalfOpen)
+	}
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatal("trial not allowed:", err)
+	}
+	if _, err := b.Allow(); err != ErrOpen {
+		t.Errorf("second trial = %v, want %v", err, ErrOpen)
+	}
+
+	// Failed trial opens again.
+	done(false)
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v, want %v", got, Open)
+	}
+
+	// Successful trial closes.
+	now = now.Add(time.Minute)
+	if err := call(true); err != nil {
+		t.Fatal("trial not allowed:", err)
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("State() = %v, want %v", got, Closed)
+	}
+}
@@ -32,6 +32,14 @@ type Cacheable interface {
 	EncodeToBytes() ([]byte, error)
 }
 
+// Expirer is implemented by results deciding how long they are cached, e.g.
+// partial results cached briefly.
+type Expirer interface {
+	// CacheExpire returns how long to cache the result, given the expiry
+	// requested.
+	CacheExpire(expire time.Duration) time.Duration
+}
+
 type GenerateFunc func(key Key) (Cacheable, error)
 
 type result struct {
@@ -63,6 +71,11 @@ func NewCacheManager(server string, maxOpen int) *CacheManager {
 	}
 }
 
+// GateStats returns stats of the gate limiting memcached connections.
+func (m *CacheManager) GateStats() gate.Stats {
+	return m.gate.Stats()
+}
+
 func (m *CacheManager) Get(key Key, tp NewableFromBytes, expire time.Duration, generate GenerateFunc) (Cacheable, error) {
 	keyString := key.String()
 
@@ -90,6 +103,9 @@ func (m *CacheManager) Get(key Key, tp NewableFromBytes, expire time.Duration, g
 func (m *CacheManager) doGenerate(key Key, keyString string, expire time.Duration, generate GenerateFunc) {
 	obj, err := generate(key)
 	if err == nil {
+		if e, ok := obj.(Expirer); ok {
+			expire = e.CacheExpire(expire)
+		}
 		// There is no errors during generating, store result in cache
 		if data, err := obj.EncodeToBytes(); err != nil {
 			log.Printf("obj.EncodeToBytes: key: %q, err: %v", keyString, err)
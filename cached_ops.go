@@ -7,21 +7,33 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ptt/pttweb/article"
 	"github.com/ptt/pttweb/atomfeed"
 	"github.com/ptt/pttweb/cache"
 	"github.com/ptt/pttweb/extcache"
+	"github.com/ptt/pttweb/gate"
+	"github.com/ptt/pttweb/page"
+	manpb "github.com/ptt/pttweb/proto/man"
 	"github.com/ptt/pttweb/pttbbs"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 const (
 	EntryPerPage = 20
 
 	CtxKeyBoardname = `ContextBoardname`
+	// CtxKeyAbsoluteLinks makes links to the site found in articles
+	// absolute with SitePrefix, e.g. for reading exported articles offline.
+	CtxKeyAbsoluteLinks = `ContextAbsoluteLinks`
 )
 
 type BbsIndexRequest struct {
@@ -96,9 +108,12 @@ type BbsSearchRequest struct {
 }
 
 func (r *BbsSearchRequest) String() string {
-	queryHash := sha256.Sum256([]byte(r.Query))
-	query := base64.URLEncoding.EncodeToString(queryHash[:])
-	return fmt.Sprintf("pttweb:bbssearch/%v/%v/%v", r.Brd.BrdName, r.Page, query)
+	return fmt.Sprintf("pttweb:bbssearch/%v/%v/%v", r.Brd.BrdName, r.Page, hashQuery(r.Query))
+}
+
+func hashQuery(query string) string {
+	h := sha256.Sum256([]byte(query))
+	return base64.URLEncoding.EncodeToString(h[:])
 }
 
 func generateBbsSearch(key cache.Key) (cache.Cacheable, error) {
@@ -164,52 +179,434 @@ func generateBbsSearch(key cache.Key) (cache.Cacheable, error) {
 	return bbsindex, nil
 }
 
-type BoardAtomFeedRequest struct {
+const (
+	// MaxConcurrentBoardFetch limits concurrent requests issued for one
+	// page covering many boards.
+	MaxConcurrentBoardFetch = 8
+)
+
+// forEachBoard calls fn for each board concurrently, and returns when all
+// calls are done.
+func forEachBoard(boards []pttbbs.Board, fn func(i int, brd pttbbs.Board)) {
+	sem := make(chan struct{}, MaxConcurrentBoardFetch)
+	var wg sync.WaitGroup
+	for i := range boards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fn(i, boards[i])
+		}(i)
+	}
+	wg.Wait()
+}
+
+// partialError returns an error if all of errs, of boards fetched by
+// forEachBoard, are errors. Results missing some boards are cached
+// briefly, see PartialResultCacheTimeout.
+func partialError(errs []error) error {
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs[0]
+}
+
+type UserProfileRequest struct {
+	UserID string
+}
+
+func (r *UserProfileRequest) String() string {
+	return fmt.Sprintf("pttweb:user/%v", strings.ToLower(r.UserID))
+}
+
+func generateUserProfile(key cache.Key) (cache.Cacheable, error) {
+	r := key.(*UserProfileRequest)
+
+	boards, err := userProfileBoards()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]page.UserBoardPosts, len(boards))
+	errs := make([]error, len(boards))
+	forEachBoard(boards, func(idx int, brd pttbbs.Board) {
+		preds := []pttbbs.SearchPredicate{pttbbs.WithAuthor(r.UserID)}
+		articles, totalPosts, err := pttSearch.Search(brd.Ref(), preds, -EntryPerPage, EntryPerPage)
+		if err != nil {
+			// Leave it empty so the board is omitted.
+			log.Println("generateUserProfile: Search:", brd.BrdName, err)
+			errs[idx] = err
+			return
+		}
+		// We may get extra entries if there are not enough posts.
+		if totalPosts < len(articles) {
+			articles = articles[:totalPosts]
+		}
+		// Newest first.
+		for i, j := 0, len(articles)-1; i < j; i, j = i+1, j-1 {
+			articles[i], articles[j] = articles[j], articles[i]
+		}
+		res := page.UserBoardPosts{
+			Board:    brd,
+			NumPosts: totalPosts,
+			Articles: articles,
+		}
+		for _, a := range articles {
+			res.RecentRecommends += a.Recommend
+		}
+		results[idx] = res
+	})
+
+	if err := partialError(errs); err != nil {
+		return nil, err
+	}
+	up := &UserProfile{
+		UserID:  r.UserID,
+		IsValid: true,
+	}
+	for i, res := range results {
+		if errs[i] != nil {
+			up.IsPartial = true
+		}
+		if res.NumPosts == 0 {
+			continue
+		}
+		up.Boards = append(up.Boards, res)
+		up.NumPosts += res.NumPosts
+		up.RecentRecommends += res.RecentRecommends
+	}
+	sort.SliceStable(up.Boards, func(i, j int) bool {
+		return up.Boards[i].NumPosts > up.Boards[j].NumPosts
+	})
+	return up, nil
+}
+
+// userProfileBoards returns hot boards and configured boards that are
+// searched for user posts. Over18 boards are excluded since the result is
+// shared by all viewers.
+func userProfileBoards() ([]pttbbs.Board, error) {
+	boards, err := ptt.Hotboards()
+	if err != nil {
+		return nil, err
+	}
+	if len(config.UserProfileBoards) > 0 {
+		var refs []pttbbs.BoardRef
+		for _, brdname := range config.UserProfileBoards {
+			refs = append(refs, pttbbs.BoardRefByName(brdname))
+		}
+		extra, err := ptt.GetBoards(refs...)
+		if err != nil {
+			return nil, err
+		}
+		boards = append(boards, extra...)
+	}
+
+	seen := make(map[pttbbs.BoardID]bool)
+	var out []pttbbs.Board
+	for _, b := range validBoards(boards) {
+		if !b.IsBoard || b.Over18 || seen[b.Bid] {
+			continue
+		}
+		seen[b.Bid] = true
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+type BoardFeedRequest struct {
 	Brd pttbbs.Board
+
+	// Query and Preds are set for a feed of search results.
+	Query string
+	Preds []pttbbs.SearchPredicate
 }
 
-func (r *BoardAtomFeedRequest) String() string {
-	return fmt.Sprintf("pttweb:atomfeed/%v", r.Brd.BrdName)
+func (r *BoardFeedRequest) String() string {
+	if r.Query == "" {
+		return fmt.Sprintf("pttweb:feed/%v", r.Brd.BrdName)
+	}
+	return fmt.Sprintf("pttweb:feed/%v/%v", r.Brd.BrdName, hashQuery(r.Query))
 }
 
-func generateBoardAtomFeed(key cache.Key) (cache.Cacheable, error) {
-	r := key.(*BoardAtomFeedRequest)
+func generateBoardFeed(key cache.Key) (cache.Cacheable, error) {
+	r := key.(*BoardFeedRequest)
 
 	if atomConverter == nil {
-		return nil, errors.New("atom feed not configured")
+		return nil, errors.New("feed not configured")
 	}
 
 	// Fetch article list
-	articles, err := ptt.GetArticleList(r.Brd.Ref(), -EntryPerPage, EntryPerPage)
+	articles, err := boardFeedArticles(r)
 	if err != nil {
 		return nil, err
 	}
-	// Fetch snippets and contruct posts.
+	// Fetch contents and contruct posts.
 	var posts []*atomfeed.PostEntry
-	for _, article := range articles {
-		// Use an empty string when error.
-		snippet, _ := getArticleSnippet(r.Brd, article.FileName)
-		posts = append(posts, &atomfeed.PostEntry{
-			Article: article,
-			Snippet: snippet,
-		})
+	for _, a := range articles {
+		post := &atomfeed.PostEntry{
+			Article: a,
+		}
+		if config.FeedFullContent {
+			fillFeedPostContent(r.Brd, post)
+		} else {
+			// Use an empty string when error.
+			post.Snippet, _ = getArticleSnippet(r.Brd, a.FileName)
+		}
+		posts = append(posts, post)
 	}
 
 	feed, err := atomConverter.Convert(r.Brd, posts)
 	if err != nil {
 		log.Println("atomfeed: Convert:", err)
 		// Don't return error but cache that it's invalid.
+	} else if r.Query != "" {
+		// Search feeds must not share the identity of the board feed.
+		feed.Title = fmt.Sprintf("%v [%v]", feed.Title, r.Query)
+		feed.ID += "?" + feedQueryValues(r.Query).Encode()
 	}
-	return &BoardAtomFeed{
+	return &BoardFeed{
 		Feed:    feed,
 		IsValid: err == nil,
 	}, nil
 }
 
+func boardFeedArticles(r *BoardFeedRequest) ([]pttbbs.Article, error) {
+	if len(r.Preds) == 0 {
+		return withPriority(ptt, gate.PriorityLow).GetArticleList(r.Brd.Ref(), -EntryPerPage, EntryPerPage)
+	}
+	articles, totalPosts, err := withPriority(pttSearch, gate.PriorityLow).Search(r.Brd.Ref(), r.Preds, -EntryPerPage, EntryPerPage)
+	if err != nil {
+		return nil, err
+	}
+	// We may get extra entries if there are not enough results.
+	if totalPosts < len(articles) {
+		articles = articles[:totalPosts]
+	}
+	return articles, nil
+}
+
+func feedQueryValues(query string) url.Values {
+	q := url.Values{}
+	q.Set("q", query)
+	return q
+}
+
+const (
+	// GroupFeedScanPerBoard is the number of latest posts of each board
+	// considered for group feeds.
+	GroupFeedScanPerBoard = 5 * EntryPerPage
+
+	// HotboardsFeedPostsPerBoard is the number of top posts of the day
+	// taken from each hot board.
+	HotboardsFeedPostsPerBoard = 1
+
+	// ClassFeedPostsPerBoard is the maximum number of newest posts taken
+	// from each board in a class.
+	ClassFeedPostsPerBoard = 5
+)
+
+// GroupFeedRequest is a feed of posts from multiple boards, either the hot
+// boards or the boards in a class.
+type GroupFeedRequest struct {
+	Hotboards bool
+	Bid       pttbbs.BoardID
+}
+
+func (r *GroupFeedRequest) String() string {
+	if r.Hotboards {
+		return "pttweb:groupfeed/hotboards"
+	}
+	return fmt.Sprintf("pttweb:groupfeed/cls/%v", r.Bid)
+}
+
+func generateGroupFeed(key cache.Key) (cache.Cacheable, error) {
+	r := key.(*GroupFeedRequest)
+
+	if atomConverter == nil {
+		return nil, errors.New("feed not configured")
+	}
+
+	var boards []pttbbs.Board
+	var title, feedURL, homeURL string
+	var err error
+	if r.Hotboards {
+		if boards, err = ptt.Hotboards(); err != nil {
+			return nil, err
+		}
+		title = config.HotboardsFeedTitle
+		feedURL, err = siteURL("atomfeed_hotboards")
+		if err != nil {
+			return nil, err
+		}
+		homeURL, err = siteURL("hotboards")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cls, err := pttbbs.OneBoard(ptt.GetBoards(pttbbs.BoardRefByBid(r.Bid)))
+		if err != nil {
+			return nil, err
+		}
+		if boards, err = ptt.GetBoards(pttbbs.BoardRefsByBid(cls.Children)...); err != nil {
+			return nil, err
+		}
+		if title, err = atomConverter.FeedTitle(cls); err != nil {
+			return nil, err
+		}
+		bid := strconv.FormatUint(uint64(r.Bid), 10)
+		feedURL, err = siteURL("atomfeed_cls", "bid", bid)
+		if err != nil {
+			return nil, err
+		}
+		homeURL, err = siteURL("classlist", "bid", bid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Group feeds are public, without the over18 check of board feeds.
+	var feedable []pttbbs.Board
+	for _, b := range validBoards(boards) {
+		if b.IsBoard && !b.Over18 {
+			feedable = append(feedable, b)
+		}
+	}
+
+	type pickedPost struct {
+		brd  pttbbs.Board
+		post *atomfeed.PostEntry
+	}
+	picked := make([][]pickedPost, len(feedable))
+	errs := make([]error, len(feedable))
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	forEachBoard(feedable, func(i int, brd pttbbs.Board) {
+		articles, err := withPriority(ptt, gate.PriorityLow).GetArticleList(brd.Ref(), -GroupFeedScanPerBoard, GroupFeedScanPerBoard)
+		if err != nil {
+			log.Println("generateGroupFeed: GetArticleList:", brd.BrdName, err)
+			errs[i] = err
+			return
+		}
+		if r.Hotboards {
+			articles = topArticlesSince(articles, dayAgo, HotboardsFeedPostsPerBoard)
+		} else if len(articles) > ClassFeedPostsPerBoard {
+			articles = articles[len(articles)-ClassFeedPostsPerBoard:]
+		}
+		for _, a := range articles {
+			picked[i] = append(picked[i], pickedPost{
+				brd: brd,
+				post: &atomfeed.PostEntry{
+					Article: a,
+					BrdName: brd.BrdName,
+				},
+			})
+		}
+	})
+
+	if err := partialError(errs); err != nil {
+		return nil, err
+	}
+	isPartial := false
+	var all []pickedPost
+	for i, p := range picked {
+		all = append(all, p...)
+		isPartial = isPartial || errs[i] != nil
+	}
+	// Oldest first, as in board feeds.
+	sort.SliceStable(all, func(i, j int) bool {
+		ti, _ := pttbbs.ParseFileNameTime(all[i].post.Article.FileName)
+		tj, _ := pttbbs.ParseFileNameTime(all[j].post.Article.FileName)
+		return ti.Before(tj)
+	})
+	if !r.Hotboards && len(all) > EntryPerPage {
+		all = all[len(all)-EntryPerPage:]
+	}
+
+	// Fetch contents of the posts in the feed only.
+	posts := make([]*atomfeed.PostEntry, len(all))
+	brds := make([]pttbbs.Board, len(all))
+	for i, p := range all {
+		posts[i], brds[i] = p.post, p.brd
+	}
+	forEachBoard(brds, func(i int, brd pttbbs.Board) {
+		if config.FeedFullContent {
+			fillFeedPostContent(brd, posts[i])
+		} else {
+			// Use an empty string when error.
+			posts[i].Snippet, _ = getArticleSnippet(brd, posts[i].Article.FileName)
+		}
+	})
+
+	feed, err := atomConverter.ConvertGroup(title, feedURL, homeURL, posts)
+	if err != nil {
+		log.Println("atomfeed: ConvertGroup:", err)
+		// Don't return error but cache that it's invalid.
+	}
+	return &BoardFeed{
+		Feed:      feed,
+		IsValid:   err == nil,
+		IsPartial: isPartial,
+	}, nil
+}
+
+// topArticlesSince returns at most n articles posted after t with the most
+// recommends.
+func topArticlesSince(articles []pttbbs.Article, t time.Time, n int) []pttbbs.Article {
+	var recent []pttbbs.Article
+	for _, a := range articles {
+		if posted, err := pttbbs.ParseFileNameTime(a.FileName); err == nil && posted.After(t) {
+			recent = append(recent, a)
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].Recommend > recent[j].Recommend
+	})
+	if len(recent) > n {
+		recent = recent[:n]
+	}
+	return recent
+}
+
+func siteURL(routeName string, pairs ...string) (string, error) {
+	u, err := router.Get(routeName).URLPath(pairs...)
+	if err != nil {
+		return "", err
+	}
+	return config.SitePrefix + u.String(), nil
+}
+
+// fillFeedPostContent fills in the full content of the post from the article
+// cache. The snippet is used instead when the article is not available.
+func fillFeedPostContent(brd pttbbs.Board, post *atomfeed.PostEntry) {
+	obj, err := cacheMgr.Get(bbsArticleRequest(&brd, post.Article.FileName, gate.PriorityLow), ZeroArticle, ArticleCacheTimeout, generateArticle)
+	if err != nil || !obj.(*Article).IsValid {
+		post.Snippet, _ = getArticleSnippet(brd, post.Article.FileName)
+		return
+	}
+	ar := obj.(*Article)
+	maxSize := config.FeedMaxContentSize
+	if len(ar.ContentTailHtml) > 0 {
+		// Pushes are mostly in the tail, and counted in push stats.
+		post.ContentTail, _ = article.InlineStyledHTML(ar.ContentTailHtml, maxSize/4)
+		maxSize -= len(post.ContentTail)
+	}
+	// The tail may overshoot its share, and zero would be uncapped.
+	if maxSize > 0 {
+		post.Content, post.ContentTruncated = article.InlineStyledHTML(ar.ContentHtml, maxSize)
+	}
+	post.ContentTruncated = post.ContentTruncated || ar.IsTruncated || maxSize <= 0
+	post.PushStats = ar.PushStats
+}
+
 const SnippetHeadSize = 16 * 1024 // Enough for 8 pages of 80x24.
 
 func getArticleSnippet(brd pttbbs.Board, filename string) (string, error) {
-	p, err := ptt.GetArticleSelect(brd.Ref(), pttbbs.SelectHead, filename, "", 0, SnippetHeadSize)
+	p, err := withPriority(ptt, gate.PriorityLow).GetArticleSelect(brd.Ref(), pttbbs.SelectHead, filename, "", 0, SnippetHeadSize)
 	if err != nil {
 		return "", err
 	}
@@ -230,8 +627,21 @@ const (
 
 	HeadSize = 100 * 1024
 	TailSize = 50 * 1024
+
+	// MiddleChunkSize is the size of each chunk of the middle of truncated
+	// articles loaded after the page.
+	MiddleChunkSize = 64 * 1024
+
+	// ManArticlePartSize is the size of man article parts loaded after the
+	// page.
+	ManArticlePartSize = 64 * 1024
 )
 
+// renderArticle renders with the rendering profile of the board.
+func renderArticle(brd *pttbbs.Board, opts ...article.RenderOption) (article.RenderedArticle, error) {
+	return article.Render(append(opts, config.renderOptions(brd)...)...)
+}
+
 type ArticleRequest struct {
 	Namespace string
 	Brd       pttbbs.Board
@@ -240,7 +650,7 @@ type ArticleRequest struct {
 }
 
 func (r *ArticleRequest) String() string {
-	return fmt.Sprintf("pttweb:%v/%v/%v", r.Namespace, r.Brd.BrdName, r.Filename)
+	return fmt.Sprintf("pttweb:%v/%v/%v%v", r.Namespace, r.Brd.BrdName, r.Filename, config.renderProfileKey(&r.Brd))
 }
 
 func (r *ArticleRequest) Boardname() string {
@@ -284,7 +694,7 @@ func generateArticle(key cache.Key) (cache.Cacheable, error) {
 			return nil, err
 		}
 		if len(ptail.Content) > 0 {
-			ra, err := article.Render(
+			ra, err := renderArticle(&r.Brd,
 				article.WithContent(ptail.Content),
 				article.WithContext(ctx),
 				article.WithDisableArticleHeader(),
@@ -293,15 +703,18 @@ func generateArticle(key cache.Key) (cache.Cacheable, error) {
 				return nil, err
 			}
 			a.ContentTailHtml = ra.HTML()
+			a.PushStats.Add(ra.PushStats())
 		}
 		a.CacheKey = ptail.CacheKey
 		a.NextOffset = ptail.FileSize - TailSize + ptail.Offset + ptail.Length
+		a.MiddleOffset = p.Offset + p.Length
+		a.MiddleEnd = a.NextOffset - ptail.Length
 	} else {
 		a.CacheKey = p.CacheKey
 		a.NextOffset = p.Length
 	}
 
-	ra, err := article.Render(
+	ra, err := renderArticle(&r.Brd,
 		article.WithContent(p.Content),
 		article.WithContext(ctx),
 	)
@@ -311,19 +724,23 @@ func generateArticle(key cache.Key) (cache.Cacheable, error) {
 	a.ParsedTitle = ra.ParsedTitle()
 	a.PreviewContent = ra.PreviewContent()
 	a.ContentHtml = ra.HTML()
+	a.PushStats.Add(ra.PushStats())
 	a.IsValid = true
 	return a, nil
 }
 
 type ArticlePartRequest struct {
-	Brd      pttbbs.Board
-	Filename string
-	CacheKey string
-	Offset   int
+	Namespace string
+	Brd       pttbbs.Board
+	Filename  string
+	CacheKey  string
+	Offset    int
+	// Select reads the article starting at offset.
+	Select func(cacheKey string, offset int) (*pttbbs.ArticlePart, error)
 }
 
 func (r *ArticlePartRequest) String() string {
-	return fmt.Sprintf("pttweb:bbs/%v/%v#%v,%v", r.Brd.BrdName, r.Filename, r.CacheKey, r.Offset)
+	return fmt.Sprintf("pttweb:%v/%v/%v%v#%v,%v", r.Namespace, r.Brd.BrdName, r.Filename, config.renderProfileKey(&r.Brd), r.CacheKey, r.Offset)
 }
 
 func (r *ArticlePartRequest) Boardname() string {
@@ -338,8 +755,8 @@ func generateArticlePart(key cache.Key) (cache.Cacheable, error) {
 		ctx = extcache.WithExtCache(ctx, extCache)
 	}
 
-	p, err := ptt.GetArticleSelect(r.Brd.Ref(), pttbbs.SelectHead, r.Filename, r.CacheKey, r.Offset, -1)
-	if err == pttbbs.ErrNotFound {
+	p, err := r.Select(r.CacheKey, r.Offset)
+	if err == pttbbs.ErrNotFound || grpc.Code(err) == codes.NotFound {
 		// Returns an invalid result
 		return new(ArticlePart), nil
 	}
@@ -351,9 +768,10 @@ func generateArticlePart(key cache.Key) (cache.Cacheable, error) {
 	ap.IsValid = true
 	ap.CacheKey = p.CacheKey
 	ap.NextOffset = r.Offset + p.Offset + p.Length
+	ap.HasMore = ap.NextOffset < p.FileSize
 
 	if len(p.Content) > 0 {
-		ra, err := article.Render(
+		ra, err := renderArticle(&r.Brd,
 			article.WithContent(p.Content),
 			article.WithContext(ctx),
 			article.WithDisableArticleHeader(),
@@ -367,6 +785,70 @@ func generateArticlePart(key cache.Key) (cache.Cacheable, error) {
 	return ap, nil
 }
 
+// ArticleRangeRequest is a chunk of the middle of a truncated article,
+// starting at Offset and up to End.
+type ArticleRangeRequest struct {
+	Brd      pttbbs.Board
+	Filename string
+	CacheKey string
+	Offset   int
+	End      int
+}
+
+func (r *ArticleRangeRequest) String() string {
+	return fmt.Sprintf("pttweb:bbsrange/%v/%v%v#%v,%v,%v", r.Brd.BrdName, r.Filename, config.renderProfileKey(&r.Brd), r.CacheKey, r.Offset, r.End)
+}
+
+func (r *ArticleRangeRequest) Boardname() string {
+	return r.Brd.BrdName
+}
+
+func generateArticleRange(key cache.Key) (cache.Cacheable, error) {
+	r := key.(*ArticleRangeRequest)
+	ctx := context.TODO()
+	ctx = context.WithValue(ctx, CtxKeyBoardname, r)
+	if config.Experiments.ExtCache.Enabled(fastStrHash64(r.Filename)) {
+		ctx = extcache.WithExtCache(ctx, extCache)
+	}
+
+	maxlen := r.End - r.Offset
+	if maxlen > MiddleChunkSize {
+		maxlen = MiddleChunkSize
+	}
+	p, err := ptt.GetArticleSelect(r.Brd.Ref(), pttbbs.SelectPart, r.Filename, r.CacheKey, r.Offset, maxlen)
+	if err == pttbbs.ErrNotFound {
+		// Returns an invalid result
+		return new(ArticlePart), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ap := new(ArticlePart)
+	ap.CacheKey = p.CacheKey
+	// Offsets are meaningless once the article changed.
+	if p.CacheKey != r.CacheKey {
+		ap.IsStale = true
+		return ap, nil
+	}
+	ap.IsValid = true
+	ap.NextOffset = r.Offset + p.Offset + p.Length
+	ap.HasMore = ap.NextOffset < r.End && p.Length > 0
+
+	if len(p.Content) > 0 {
+		ra, err := renderArticle(&r.Brd,
+			article.WithContent(p.Content),
+			article.WithContext(ctx),
+			article.WithDisableArticleHeader(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		ap.ContentHtml = string(ra.HTML())
+	}
+	return ap, nil
+}
+
 func truncateLargeContent(content []byte, size, maxScan int) []byte {
 	if len(content) <= size {
 		return content
@@ -378,3 +860,115 @@ func truncateLargeContent(content []byte, size, maxScan int) []byte {
 	}
 	return content[:size]
 }
+
+type ManIndexRequest struct {
+	Brd  pttbbs.Board
+	Path string
+}
+
+func (r *ManIndexRequest) String() string {
+	return fmt.Sprintf("pttweb:manindex/%v/%v", r.Brd.BrdName, r.Path)
+}
+
+func generateManIndex(key cache.Key) (cache.Cacheable, error) {
+	r := key.(*ManIndexRequest)
+
+	res, err := mand.List(context.TODO(), &manpb.ListRequest{
+		BoardName: r.Brd.BrdName,
+		Path:      r.Path,
+	}, grpc.FailFast(true))
+	if err != nil {
+		return nil, err
+	}
+
+	mi := &ManIndex{
+		IsValid: res.IsSuccess,
+	}
+	for _, e := range res.Entries {
+		mi.Entries = append(mi.Entries, ManEntry{
+			Path:  strings.Trim(e.Path, "/"),
+			Title: e.Title,
+			IsDir: e.IsDir,
+		})
+	}
+	return mi, nil
+}
+
+func getManIndex(brd *pttbbs.Board, path string) (*ManIndex, error) {
+	obj, err := cacheMgr.Get(&ManIndexRequest{
+		Brd:  *brd,
+		Path: path,
+	}, ZeroManIndex, ManIndexCacheTimeout, generateManIndex)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*ManIndex), nil
+}
+
+const (
+	// Limits of crawling a man tree for a search.
+	ManSearchMaxDirs    = 200
+	ManSearchMaxResults = 100
+	ManSearchTimeout    = 10 * time.Second
+
+	MaxManSearchQueryLength = 64
+)
+
+type ManSearchRequest struct {
+	Brd   pttbbs.Board
+	Query string
+}
+
+func (r *ManSearchRequest) String() string {
+	return fmt.Sprintf("pttweb:mansearch/%v/%v", r.Brd.BrdName, hashQuery(r.Query))
+}
+
+// generateManSearch searches titles by a breadth-first crawl of the man
+// tree, which stops at the limits above.
+func generateManSearch(key cache.Key) (cache.Cacheable, error) {
+	r := key.(*ManSearchRequest)
+	query := strings.ToLower(r.Query)
+	deadline := time.Now().Add(ManSearchTimeout)
+
+	type dir struct {
+		path  string
+		title string
+	}
+	queue := []dir{{path: "", title: r.Brd.BrdName}}
+	visited := map[string]bool{"": true}
+
+	ms := new(ManSearch)
+	for n := 0; len(queue) > 0; n++ {
+		if n >= ManSearchMaxDirs || len(ms.Results) >= ManSearchMaxResults || time.Now().After(deadline) {
+			ms.Truncated = true
+			break
+		}
+		d := queue[0]
+		queue = queue[1:]
+
+		mi, err := getManIndex(&r.Brd, d.path)
+		if err != nil {
+			if n == 0 {
+				return nil, err
+			}
+			log.Println("generateManSearch: getManIndex:", r.Brd.BrdName, d.path, err)
+			ms.Truncated = true
+			continue
+		}
+		for _, e := range mi.Entries {
+			if strings.Contains(strings.ToLower(e.Title), query) && len(ms.Results) < ManSearchMaxResults {
+				ms.Results = append(ms.Results, page.ManSearchResult{
+					Path:     e.Path,
+					Title:    e.Title,
+					IsDir:    e.IsDir,
+					DirTitle: d.title,
+				})
+			}
+			if e.IsDir && !visited[e.Path] {
+				visited[e.Path] = true
+				queue = append(queue, dir{path: e.Path, title: e.Title})
+			}
+		}
+	}
+	return ms, nil
+}
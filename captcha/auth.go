@@ -0,0 +1,144 @@
+package captcha
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMaxInsertSkewSecs = 5 * 60
+
+	MaxNonceLength = 64
+)
+
+var (
+	errNotSigned       = errors.New("request not signed")
+	errBadSignature    = errors.New("bad request signature")
+	errBadTimestamp    = errors.New("request timestamp out of range")
+	errNonceReused     = errors.New("request nonce reused")
+	errPlainSecretUsed = errors.New("plain secret not allowed")
+)
+
+// SignRequest signs parameters of a request to path, e.g. /captcha/insert,
+// with secret. It sets "ts", "nonce" and "sig" in values.
+func SignRequest(secret, path string, values url.Values, now time.Time) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	values.Del("secret")
+	values.Set("ts", strconv.FormatInt(now.Unix(), 10))
+	values.Set("nonce", hex.EncodeToString(nonce))
+	values.Set("sig", requestSignature(secret, path, values))
+	return nil
+}
+
+// requestSignature signs all values but "sig", which are sorted by key.
+func requestSignature(secret, path string, values url.Values) string {
+	v := make(url.Values, len(values))
+	for k, vs := range values {
+		if k != "sig" {
+			v[k] = vs
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(path + "?" + v.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) insertSecrets() []string {
+	var secrets []string
+	if h.config.InsertSecret != "" {
+		secrets = append(secrets, h.config.InsertSecret)
+	}
+	for _, s := range h.config.InsertSecrets {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+func (h *Handler) maxSkew() time.Duration {
+	secs := h.config.MaxInsertSkewSecs
+	if secs <= 0 {
+		secs = DefaultMaxInsertSkewSecs
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// checkSignature verifies the signature and timestamp of a request signed by
+// SignRequest with any of secrets.
+func checkSignature(secrets []string, req *http.Request, maxSkew time.Duration, now time.Time) error {
+	if err := req.ParseForm(); err != nil {
+		return err
+	}
+	sig := req.Form.Get("sig")
+	if sig == "" {
+		return errNotSigned
+	}
+	ts, err := strconv.ParseInt(req.Form.Get("ts"), 10, 64)
+	if err != nil {
+		return errBadTimestamp
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > maxSkew || d < -maxSkew {
+		return errBadTimestamp
+	}
+	if nonce := req.Form.Get("nonce"); nonce == "" || len(nonce) > MaxNonceLength {
+		return errBadSignature
+	}
+	// Check all secrets to not leak which one matches.
+	matched := 0
+	for _, secret := range secrets {
+		matched |= subtle.ConstantTimeCompare([]byte(sig), []byte(requestSignature(secret, req.URL.Path, req.Form)))
+	}
+	if matched != 1 {
+		return errBadSignature
+	}
+	return nil
+}
+
+// authenticate checks if the request is from the BBS side. Signed requests
+// are accepted once; plain secrets only if allowed.
+func (h *Handler) authenticate(req *http.Request) error {
+	secrets := h.insertSecrets()
+	if len(secrets) == 0 {
+		return errBadSignature
+	}
+
+	err := checkSignature(secrets, req, h.maxSkew(), time.Now())
+	if err == errNotSigned {
+		if !h.config.AllowPlainInsertSecret {
+			return errPlainSecretUsed
+		}
+		secret := []byte(req.Form.Get("secret"))
+		matched := 0
+		for _, s := range secrets {
+			matched |= subtle.ConstantTimeCompare(secret, []byte(s))
+		}
+		if matched != 1 {
+			return errBadSignature
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Nonces are kept until timestamps of their requests are out of range.
+	ok, err := h.redisClient.SetNX(`captcha:nonce:`+req.Form.Get("nonce"), 1, 2*h.maxSkew()).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNonceReused
+	}
+	return nil
+}
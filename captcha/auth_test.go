@@ -0,0 +1,52 @@
+package captcha
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	values := url.Values{
+		"handle": {"h1"},
+		"verify": {"v1"},
+	}
+	if err := SignRequest("new", "/captcha/insert", values, now); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(path string, values url.Values, secrets []string, at time.Time) error {
+		r := httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return checkSignature(secrets, r, time.Minute, at)
+	}
+
+	if err := check("/captcha/insert", values, []string{"old", "new"}, now); err != nil {
+		t.Error("signed request rejected:", err)
+	}
+	if err := check("/captcha/insert", values, []string{"old"}, now); err != errBadSignature {
+		t.Error("expected bad signature with retired secret, got", err)
+	}
+	if err := check("/captcha/status", values, []string{"new"}, now); err != errBadSignature {
+		t.Error("expected bad signature on another path, got", err)
+	}
+	if err := check("/captcha/insert", values, []string{"new"}, now.Add(2*time.Minute)); err != errBadTimestamp {
+		t.Error("expected bad timestamp, got", err)
+	}
+
+	tampered := url.Values{}
+	for k, v := range values {
+		tampered[k] = v
+	}
+	tampered.Set("verify", "v2")
+	if err := check("/captcha/insert", tampered, []string{"new"}, now); err != errBadSignature {
+		t.Error("expected bad signature for tampered values, got", err)
+	}
+
+	if err := check("/captcha/insert", url.Values{"secret": {"new"}}, []string{"new"}, now); err != errNotSigned {
+		t.Error("expected not signed, got", err)
+	}
+}
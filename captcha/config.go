@@ -1,16 +1,69 @@
 package captcha
 
+const (
+	ProviderRecaptcha   = "recaptcha"
+	ProviderRecaptchaV3 = "recaptcha_v3"
+	ProviderHCaptcha    = "hcaptcha"
+	ProviderTurnstile   = "turnstile"
+	ProviderLocal       = "local"
+)
+
 type Config struct {
-	Enabled      bool
-	InsertSecret string
-	ExpireSecs   int
-	Recaptcha    RecaptchaConfig
-	Redis        RedisConfig
+	Enabled bool
+
+	// Requests to insert handles or query status are signed with
+	// SignRequest by any of InsertSecret and InsertSecrets. Timestamps of
+	// requests may be off by MaxInsertSkewSecs. Sending the secret as is
+	// is only allowed with AllowPlainInsertSecret.
+	InsertSecret           string
+	InsertSecrets          []string
+	MaxInsertSkewSecs      int
+	AllowPlainInsertSecret bool
+
+	ExpireSecs int
+
+	// Failed verifications are limited per handle and per client IP within
+	// AttemptWindowSecs. Zero values take defaults.
+	MaxAttemptsPerHandle int
+	MaxAttemptsPerIP     int
+	AttemptWindowSecs    int
+
+	// Provider selects the captcha provider, one of Provider* constants.
+	// Defaults to ProviderRecaptcha.
+	Provider  string
+	Recaptcha RecaptchaConfig
+	HCaptcha  SiteVerifyConfig
+	Turnstile SiteVerifyConfig
+	Local     LocalConfig
+
+	Redis RedisConfig
 }
 
 type RecaptchaConfig struct {
 	SiteKey string
 	Secret  string
+
+	// MinScore is the lowest score accepted by reCAPTCHA v3. Defaults to
+	// DefaultRecaptchaMinScore.
+	MinScore float64
+	// Action, if non-empty, is the action name of reCAPTCHA v3 that
+	// responses must match.
+	Action string
+}
+
+// SiteVerifyConfig configures providers with a siteverify API compatible
+// with reCAPTCHA.
+type SiteVerifyConfig struct {
+	SiteKey string
+	Secret  string
+}
+
+// LocalConfig configures the self-hosted arithmetic captcha.
+type LocalConfig struct {
+	// Secret signs challenges.
+	Secret string
+	// Image renders the question as an image instead of text.
+	Image bool
 }
 
 // See https://godoc.org/github.com/go-redis/redis#Options
@@ -1,25 +1,32 @@
 package captcha
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	"github.com/ptt/pttweb/page"
-	"github.com/rvelhote/go-recaptcha"
 )
 
 const (
-	RecaptchaURL       = `https://www.google.com/recaptcha/api/siteverify`
-	GRecaptchaResponse = `g-recaptcha-response`
-	CaptchaHandle      = `handle`
+	CaptchaHandle = `handle`
 
 	MaxCaptchaHandleLength = 80
+
+	// DefaultIssueWindowSecs is the period clients get the same handle
+	// from IssueHandle if captchas don't expire.
+	DefaultIssueWindowSecs = 10 * 60
 )
 
 var (
@@ -31,6 +38,14 @@ var (
 		error:          errors.New("captcha verfication failed"),
 		SetCaptchaPage: func(p *page.Captcha) { p.CaptchaErr.IsVerifyFailed = true },
 	}
+	ErrCaptchaHandleUsed = &CaptchaErr{
+		error:          errors.New("captcha handle already used"),
+		SetCaptchaPage: func(p *page.Captcha) { p.CaptchaErr.IsUsed = true },
+	}
+	ErrCaptchaTooManyAttempts = &CaptchaErr{
+		error:          errors.New("too many captcha attempts"),
+		SetCaptchaPage: func(p *page.Captcha) { p.CaptchaErr.IsTooManyAttempts = true },
+	}
 )
 
 type CaptchaErr struct {
@@ -42,22 +57,44 @@ type Handler struct {
 	config      *Config
 	router      *mux.Router
 	redisClient *redis.Client
+	verifier    Verifier
+
+	// handleKey derives handles issued to clients, see IssueHandle.
+	handleKey []byte
+
+	// OnSolved, if set, is called with the request solving a captcha.
+	OnSolved func(r *http.Request)
+
+	// clientIP finds the client IP of requests, e.g. behind proxies.
+	clientIP func(r *http.Request) string
 }
 
-func Install(cfg *Config, r *mux.Router) error {
+// Install installs captcha routes to r. clientIP finds the client IP of
+// requests, and should know about proxies in front of us.
+func Install(cfg *Config, r *mux.Router, clientIP func(r *http.Request) string) (*Handler, error) {
 	redisClient := redis.NewClient(&redis.Options{
 		Network:  cfg.Redis.Network,
 		Addr:     cfg.Redis.Addr,
 		Password: cfg.Redis.Password,
 		DB:       cfg.Redis.DB,
 	})
+	verifier, err := newVerifier(cfg, redisClient)
+	if err != nil {
+		return nil, err
+	}
 	h := &Handler{
 		config:      cfg,
 		router:      r,
 		redisClient: redisClient,
+		verifier:    verifier,
+		handleKey:   make([]byte, 32),
+		clientIP:    clientIP,
+	}
+	if _, err := rand.Read(h.handleKey); err != nil {
+		return nil, err
 	}
 	h.installRoutes(r)
-	return nil
+	return h, nil
 }
 
 func (h *Handler) installRoutes(r *mux.Router) {
@@ -68,6 +105,10 @@ func (h *Handler) installRoutes(r *mux.Router) {
 	r.Path(`/captcha/insert`).
 		Handler(page.ErrorWrapper(h.handleCaptchaInsert)).
 		Name("captcha_insert")
+
+	r.Path(`/captcha/status`).
+		Handler(page.ErrorWrapper(h.handleCaptchaStatus)).
+		Name("captcha_status")
 }
 
 func (h *Handler) handleCaptcha(ctx page.Context, w http.ResponseWriter) error {
@@ -80,8 +121,7 @@ func (h *Handler) handleCaptcha(ctx page.Context, w http.ResponseWriter) error {
 
 func (h *Handler) handleCaptchaInternal(ctx page.Context, w http.ResponseWriter) (*page.Captcha, error) {
 	p := &page.Captcha{
-		Handle:           ctx.Request().FormValue(CaptchaHandle),
-		RecaptchaSiteKey: h.config.Recaptcha.SiteKey,
+		Handle: ctx.Request().FormValue(CaptchaHandle),
 	}
 	if u, err := h.router.Get("captcha").URLPath(); err != nil {
 		return nil, err
@@ -90,26 +130,75 @@ func (h *Handler) handleCaptchaInternal(ctx page.Context, w http.ResponseWriter)
 		q.Set(CaptchaHandle, ctx.Request().FormValue(CaptchaHandle))
 		p.PostAction = u.String() + "?" + q.Encode()
 	}
+	req := ctx.Request()
+	ev := &AuditEvent{
+		Handle: p.Handle,
+		Time:   time.Now(),
+		IP:     h.clientIP(req),
+	}
 	// Check if the handle is valid.
 	if _, err := h.fetchVerificationKey(p.Handle); err != nil {
+		if err == ErrCaptchaHandleNotFound && req.Method == "POST" {
+			ev.Outcome = OutcomeHandleInvalid
+			h.audit(ev)
+		}
 		return translateCaptchaErr(p, err)
 	}
-	if response := ctx.Request().PostFormValue(GRecaptchaResponse); response != "" {
-		r := recaptcha.Recaptcha{
-			PrivateKey: h.config.Recaptcha.Secret,
-			URL:        RecaptchaURL,
+	if solved, _, err := h.isSolved(p.Handle); err != nil {
+		return nil, err
+	} else if solved {
+		return translateCaptchaErr(p, ErrCaptchaHandleUsed)
+	}
+	attempt := req.Method == "POST"
+	if attempt {
+		if err := h.takeAttempt(p.Handle, ev.IP); err != nil {
+			if err == ErrCaptchaTooManyAttempts {
+				ev.Outcome = OutcomeRateLimited
+				h.audit(ev)
+			}
+			return translateCaptchaErr(p, err)
 		}
-		verifyResp, errs := r.Verify(response, "")
-		if len(errs) != 0 || !verifyResp.Success {
-			p.InternalErrMessage = fmt.Sprintf("%v", errs)
-			return translateCaptchaErr(p, ErrCaptchaVerifyFailed)
-		} else if verifyResp.Success {
-			var err error
-			p.VerificationKey, err = h.fetchVerificationKey(p.Handle)
-			if err != nil {
-				return translateCaptchaErr(p, err)
+	} else if err := h.checkAttempts(p.Handle, ev.IP); err != nil {
+		return translateCaptchaErr(p, err)
+	}
+	// Set up a new challenge in case the response is missing or wrong.
+	if err := h.verifier.SetupPage(p); err != nil {
+		return nil, err
+	}
+	err := h.verifier.Verify(req)
+	if attempt && (err == nil || err == errNoResponse) {
+		// Only failures count.
+		if err := h.returnAttempt(p.Handle, ev.IP); err != nil {
+			log.Println("captcha: return attempt:", err)
+		}
+	}
+	switch err {
+	case errNoResponse:
+	case nil:
+		if err := h.markSolved(p.Handle, ev.Time); err != nil {
+			if err == ErrCaptchaHandleUsed {
+				ev.Outcome = OutcomeReused
+				h.audit(ev)
 			}
+			return translateCaptchaErr(p, err)
+		}
+		ev.Outcome = OutcomeSolved
+		h.audit(ev)
+		e, err := h.fetchEntry(p.Handle)
+		if err != nil {
+			return translateCaptchaErr(p, err)
 		}
+		p.VerificationKey = e.Verify
+		p.ReturnTo = e.ReturnTo
+		if h.OnSolved != nil {
+			h.OnSolved(req)
+		}
+	default:
+		ev.Outcome = OutcomeFailed
+		ev.Message = err.Error()
+		h.audit(ev)
+		p.InternalErrMessage = fmt.Sprintf("%v", err)
+		return translateCaptchaErr(p, ErrCaptchaVerifyFailed)
 	}
 	return p, nil
 }
@@ -126,32 +215,72 @@ func translateCaptchaErr(p *page.Captcha, err error) (*page.Captcha, error) {
 }
 
 func (h *Handler) fetchVerificationKey(handle string) (string, error) {
+	e, err := h.fetchEntry(handle)
+	if err != nil {
+		return "", err
+	}
+	return e.Verify, nil
+}
+
+func (h *Handler) fetchEntry(handle string) (*CaptchaEntry, error) {
 	if len(handle) > MaxCaptchaHandleLength {
-		return "", ErrCaptchaHandleNotFound
+		return nil, ErrCaptchaHandleNotFound
 	}
 	data, err := h.redisClient.Get(handle).Result()
 	if err == redis.Nil {
-		return "", ErrCaptchaHandleNotFound
+		return nil, ErrCaptchaHandleNotFound
 	} else if err != nil {
+		return nil, err
+	}
+	return decodeCaptchaEntry(data)
+}
+
+// IssueHandle inserts a handle for visitors of the site, e.g. those being
+// rate limited, who are sent back to returnTo after solving the captcha.
+// returnTo must be a path on this site. A client gets the same handle for
+// the period of captcha expiry, returning to where it was first sent from,
+// so that repeated requests don't insert more.
+func (h *Handler) IssueHandle(r *http.Request, returnTo string) (string, error) {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		return "", errors.New("return path not on site")
+	}
+	period := int64(h.expire() / time.Second)
+	if period <= 0 {
+		period = DefaultIssueWindowSecs
+	}
+	window := time.Now().Unix() / period
+	mac := hmac.New(sha256.New, h.handleKey)
+	fmt.Fprintf(mac, "%v/%v", h.clientIP(r), window)
+	handle := "web-" + hex.EncodeToString(mac.Sum(nil)[:16])
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
 		return "", err
 	}
-	e, err := decodeCaptchaEntry(data)
+	data, err := encodeCaptchaEntry(&CaptchaEntry{
+		Handle:   handle,
+		Verify:   hex.EncodeToString(buf),
+		ReturnTo: returnTo,
+	})
 	if err != nil {
 		return "", err
 	}
-	return e.Verify, nil
+	if err := h.redisClient.SetNX(handle, data, h.expire()).Err(); err != nil {
+		return "", err
+	}
+	return handle, nil
 }
 
 func (h *Handler) handleCaptchaInsert(ctx page.Context, w http.ResponseWriter) error {
 	req := ctx.Request()
-	secret := req.FormValue("secret")
 	handle := req.FormValue("handle")
 	verify := req.FormValue("verify")
-	if secret == "" || handle == "" || verify == "" || len(handle) > MaxCaptchaHandleLength {
+	if handle == "" || verify == "" || len(handle) > MaxCaptchaHandleLength {
 		w.WriteHeader(http.StatusBadRequest)
 		return nil
 	}
-	if secret != h.config.InsertSecret {
+	if err := h.authenticate(req); err != nil {
+		log.Println("captcha: insert:", err)
 		w.WriteHeader(http.StatusForbidden)
 		return nil
 	}
@@ -175,8 +304,9 @@ func (h *Handler) handleCaptchaInsert(ctx page.Context, w http.ResponseWriter) e
 }
 
 type CaptchaEntry struct {
-	Handle string `json:"h"`
-	Verify string `json:"v"`
+	Handle   string `json:"h"`
+	Verify   string `json:"v"`
+	ReturnTo string `json:"r,omitempty"`
 }
 
 func encodeCaptchaEntry(e *CaptchaEntry) (string, error) {
@@ -0,0 +1,210 @@
+package captcha
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ptt/pttweb/page"
+)
+
+const (
+	DefaultMaxAttemptsPerHandle = 5
+	DefaultMaxAttemptsPerIP     = 20
+	DefaultAttemptWindowSecs    = 60 * 60
+
+	// MaxAuditEvents is the number of latest audit events kept in Redis.
+	MaxAuditEvents = 10000
+
+	auditKey = `captcha:audit`
+)
+
+const (
+	OutcomeSolved        = "solved"
+	OutcomeFailed        = "failed"
+	OutcomeReused        = "reused"
+	OutcomeRateLimited   = "rate_limited"
+	OutcomeHandleInvalid = "handle_invalid"
+)
+
+// AuditEvent records a verification attempt.
+type AuditEvent struct {
+	Handle  string    `json:"handle"`
+	Time    time.Time `json:"time"`
+	IP      string    `json:"ip"`
+	Outcome string    `json:"outcome"`
+	Message string    `json:"message,omitempty"`
+}
+
+// HandleStatus is the response of the status endpoint.
+type HandleStatus struct {
+	Handle   string `json:"handle"`
+	Exists   bool   `json:"exists"`
+	Solved   bool   `json:"solved"`
+	SolvedAt int64  `json:"solvedAt,omitempty"`
+}
+
+func solvedKey(handle string) string {
+	return `captcha:solved:` + handle
+}
+
+func handleAttemptsKey(handle string) string {
+	return `captcha:fail:h:` + handle
+}
+
+func ipAttemptsKey(ip string) string {
+	return `captcha:fail:ip:` + ip
+}
+
+func (h *Handler) expire() time.Duration {
+	return time.Duration(h.config.ExpireSecs) * time.Second
+}
+
+func (h *Handler) attemptWindow() time.Duration {
+	secs := h.config.AttemptWindowSecs
+	if secs <= 0 {
+		secs = DefaultAttemptWindowSecs
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func (h *Handler) maxAttempts() (perHandle, perIP int) {
+	perHandle = h.config.MaxAttemptsPerHandle
+	if perHandle <= 0 {
+		perHandle = DefaultMaxAttemptsPerHandle
+	}
+	perIP = h.config.MaxAttemptsPerIP
+	if perIP <= 0 {
+		perIP = DefaultMaxAttemptsPerIP
+	}
+	return
+}
+
+// checkAttempts returns ErrCaptchaTooManyAttempts if the handle or the ip
+// failed too many times. It is for showing the page only; attempts are
+// counted by takeAttempt.
+func (h *Handler) checkAttempts(handle, ip string) error {
+	maxHandle, maxIP := h.maxAttempts()
+	for _, c := range []struct {
+		key string
+		max int
+	}{
+		{handleAttemptsKey(handle), maxHandle},
+		{ipAttemptsKey(ip), maxIP},
+	} {
+		n, err := h.redisClient.Get(c.key).Int64()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if n >= int64(c.max) {
+			return ErrCaptchaTooManyAttempts
+		}
+	}
+	return nil
+}
+
+// takeAttempt counts an attempt of the handle and the ip, and returns
+// ErrCaptchaTooManyAttempts if either is over the limit. Counting and
+// checking is a single INCR, so concurrent attempts can't exceed the limit.
+// Attempts not failing are given back by returnAttempt.
+func (h *Handler) takeAttempt(handle, ip string) error {
+	window := h.attemptWindow()
+	var handleCount, ipCount *redis.IntCmd
+	_, err := h.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		handleCount = pipe.Incr(handleAttemptsKey(handle))
+		pipe.Expire(handleAttemptsKey(handle), window)
+		ipCount = pipe.Incr(ipAttemptsKey(ip))
+		pipe.Expire(ipAttemptsKey(ip), window)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	maxHandle, maxIP := h.maxAttempts()
+	if handleCount.Val() > int64(maxHandle) || ipCount.Val() > int64(maxIP) {
+		return ErrCaptchaTooManyAttempts
+	}
+	return nil
+}
+
+// returnAttempt uncounts an attempt taken by takeAttempt.
+func (h *Handler) returnAttempt(handle, ip string) error {
+	_, err := h.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Decr(handleAttemptsKey(handle))
+		pipe.Decr(ipAttemptsKey(ip))
+		return nil
+	})
+	return err
+}
+
+// markSolved marks the handle solved. It returns ErrCaptchaHandleUsed if the
+// handle has been solved, so that the verification key is revealed once.
+func (h *Handler) markSolved(handle string, now time.Time) error {
+	ok, err := h.redisClient.SetNX(solvedKey(handle), now.Unix(), h.expire()).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCaptchaHandleUsed
+	}
+	return nil
+}
+
+func (h *Handler) isSolved(handle string) (bool, int64, error) {
+	at, err := h.redisClient.Get(solvedKey(handle)).Int64()
+	if err == redis.Nil {
+		return false, 0, nil
+	} else if err != nil {
+		return false, 0, err
+	}
+	return true, at, nil
+}
+
+func (h *Handler) audit(e *AuditEvent) {
+	log.Printf("captcha: handle=%q ip=%v outcome=%v %v", e.Handle, e.IP, e.Outcome, e.Message)
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Println("captcha: audit:", err)
+		return
+	}
+	if _, err := h.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LPush(auditKey, data)
+		pipe.LTrim(auditKey, 0, MaxAuditEvents-1)
+		return nil
+	}); err != nil {
+		log.Println("captcha: audit:", err)
+	}
+}
+
+func (h *Handler) handleCaptchaStatus(ctx page.Context, w http.ResponseWriter) error {
+	req := ctx.Request()
+	handle := req.FormValue("handle")
+	if handle == "" || len(handle) > MaxCaptchaHandleLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+	if err := h.authenticate(req); err != nil {
+		log.Println("captcha: status:", err)
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+
+	st := HandleStatus{Handle: handle}
+	if _, err := h.fetchVerificationKey(handle); err == nil {
+		st.Exists = true
+	} else if err != ErrCaptchaHandleNotFound {
+		return err
+	}
+	var err error
+	if st.Solved, st.SolvedAt, err = h.isSolved(handle); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(&st)
+}
@@ -0,0 +1,255 @@
+package captcha
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ptt/pttweb/page"
+)
+
+const (
+	LocalAnswer    = `captcha-answer`
+	LocalChallenge = `captcha-challenge`
+
+	// DefaultLocalExpire is the validity of challenges when the expiry of
+	// handles is not configured.
+	DefaultLocalExpire = 10 * time.Minute
+
+	localGlyphScale = 4
+	localNoiseDots  = 120
+)
+
+var (
+	errLocalChallengeInvalid = errors.New("invalid challenge")
+	errLocalChallengeExpired = errors.New("challenge expired")
+	errLocalWrongAnswer      = errors.New("wrong answer")
+	errLocalChallengeUsed    = errors.New("challenge already used")
+)
+
+// localVerifier is a self-hosted captcha asking for the result of a simple
+// arithmetic question. The answer and the handle are bound to the challenge
+// by HMAC, and each challenge may be solved once.
+type localVerifier struct {
+	secret []byte
+	image  bool
+	expire time.Duration
+
+	// useNonce marks the nonce of a challenge used until expire. It returns
+	// false if the nonce was used already.
+	useNonce func(nonce string, expire time.Time) (bool, error)
+}
+
+// redisNonceUser makes nonces single-use with keys in redis.
+func redisNonceUser(client *redis.Client) func(string, time.Time) (bool, error) {
+	return func(nonce string, expire time.Time) (bool, error) {
+		return client.SetNX(`captcha:local:`+nonce, 1, time.Until(expire)).Result()
+	}
+}
+
+func (v *localVerifier) SetupPage(p *page.Captcha) error {
+	question, answer, err := newLocalQuestion()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	expire := v.expire
+	if expire <= 0 {
+		expire = DefaultLocalExpire
+	}
+	payload := fmt.Sprintf("%v:%v", time.Now().Add(expire).Unix(), hex.EncodeToString(nonce))
+
+	p.Provider = ProviderLocal
+	p.ResponseField = LocalAnswer
+	p.LocalChallenge = payload + "." + v.sign(payload, p.Handle, answer)
+	if v.image {
+		img, err := renderLocalQuestion(question)
+		if err != nil {
+			return err
+		}
+		p.LocalImage = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(img))
+	} else {
+		p.LocalQuestion = question
+	}
+	return nil
+}
+
+func (v *localVerifier) Verify(r *http.Request) error {
+	answer := strings.TrimSpace(r.PostFormValue(LocalAnswer))
+	challenge := r.PostFormValue(LocalChallenge)
+	if answer == "" || challenge == "" {
+		return errNoResponse
+	}
+	n, err := strconv.Atoi(answer)
+	if err != nil {
+		return errLocalWrongAnswer
+	}
+
+	i := strings.LastIndexByte(challenge, '.')
+	if i < 0 {
+		return errLocalChallengeInvalid
+	}
+	payload, sig := challenge[:i], challenge[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(v.sign(payload, r.FormValue(CaptchaHandle), n))) {
+		return errLocalWrongAnswer
+	}
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) != 2 {
+		return errLocalChallengeInvalid
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errLocalChallengeInvalid
+	}
+	if time.Now().Unix() >= exp {
+		return errLocalChallengeExpired
+	}
+	if ok, err := v.useNonce(parts[1], time.Unix(exp, 0)); err != nil {
+		return err
+	} else if !ok {
+		return errLocalChallengeUsed
+	}
+	return nil
+}
+
+func (v *localVerifier) sign(payload, handle string, answer int) string {
+	mac := hmac.New(sha256.New, v.secret)
+	fmt.Fprintf(mac, "%v:%v:%v", payload, handle, answer)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randInt(n int) (int, error) {
+	r, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(r.Int64()), nil
+}
+
+// newLocalQuestion asks for a sum, difference or product of two numbers,
+// with answers up to about a thousand.
+func newLocalQuestion() (question string, answer int, err error) {
+	var a, b, op int
+	if op, err = randInt(3); err != nil {
+		return
+	}
+	if op == 2 {
+		// Products of 2..9 and 10..99.
+		if a, err = randInt(90); err != nil {
+			return
+		}
+		if b, err = randInt(8); err != nil {
+			return
+		}
+		a, b = a+10, b+2
+		return fmt.Sprintf("%v x %v = ?", a, b), a * b, nil
+	}
+	if a, err = randInt(900); err != nil {
+		return
+	}
+	if b, err = randInt(90); err != nil {
+		return
+	}
+	a, b = a+100, b+10
+	if op == 0 {
+		return fmt.Sprintf("%v + %v = ?", a, b), a + b, nil
+	}
+	return fmt.Sprintf("%v - %v = ?", a, b), a - b, nil
+}
+
+// 5x7 glyphs of characters in questions.
+var localGlyphs = map[rune][7]string{
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'+': {".....", "..#..", "..#..", "#####", "..#..", "..#..", "....."},
+	'-': {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'x': {".....", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "....."},
+	'=': {".....", ".....", "#####", ".....", "#####", ".....", "....."},
+	'?': {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+	' ': {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+}
+
+// renderLocalQuestion draws the question into a PNG image with some jitter
+// and noise.
+func renderLocalQuestion(question string) ([]byte, error) {
+	const (
+		s      = localGlyphScale
+		cellW  = 6 * s
+		margin = 2 * s
+		height = 7*s + 2*margin + 2*s
+	)
+	width := len(question)*cellW + 2*margin
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{
+		color.White,
+		color.RGBA{0x20, 0x20, 0x60, 0xff},
+		color.RGBA{0xa0, 0xa0, 0xa0, 0xff},
+	})
+
+	for i, c := range question {
+		glyph, ok := localGlyphs[c]
+		if !ok {
+			continue
+		}
+		jitter, err := randInt(2*s + 1)
+		if err != nil {
+			return nil, err
+		}
+		x0, y0 := margin+i*cellW, margin+jitter
+		for y, row := range glyph {
+			for x, dot := range row {
+				if dot != '#' {
+					continue
+				}
+				for dy := 0; dy < s; dy++ {
+					for dx := 0; dx < s; dx++ {
+						img.SetColorIndex(x0+x*s+dx, y0+y*s+dy, 1)
+					}
+				}
+			}
+		}
+	}
+
+	for i := 0; i < localNoiseDots; i++ {
+		x, err := randInt(width)
+		if err != nil {
+			return nil, err
+		}
+		y, err := randInt(height)
+		if err != nil {
+			return nil, err
+		}
+		img.SetColorIndex(x, y, 2)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
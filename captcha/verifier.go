@@ -0,0 +1,183 @@
+package captcha
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ptt/pttweb/page"
+)
+
+const (
+	RecaptchaURL       = `https://www.google.com/recaptcha/api/siteverify`
+	RecaptchaScriptURL = `https://www.google.com/recaptcha/api.js`
+	HCaptchaURL        = `https://api.hcaptcha.com/siteverify`
+	HCaptchaScriptURL  = `https://js.hcaptcha.com/1/api.js`
+	TurnstileURL       = `https://challenges.cloudflare.com/turnstile/v0/siteverify`
+	TurnstileScriptURL = `https://challenges.cloudflare.com/turnstile/v0/api.js`
+
+	GRecaptchaResponse = `g-recaptcha-response`
+	HCaptchaResponse   = `h-captcha-response`
+	TurnstileResponse  = `cf-turnstile-response`
+
+	DefaultRecaptchaMinScore = 0.5
+
+	SiteVerifyTimeout = 10 * time.Second
+)
+
+var (
+	// errNoResponse indicates the request carries no captcha response, and
+	// the captcha should be shown.
+	errNoResponse = errors.New("no captcha response")
+)
+
+// Verifier is a captcha provider.
+type Verifier interface {
+	// SetupPage fills in what the captcha page needs to show a challenge.
+	SetupPage(p *page.Captcha) error
+
+	// Verify checks the response of the user in the request. It returns
+	// errNoResponse if there is none.
+	Verify(r *http.Request) error
+}
+
+func newVerifier(cfg *Config, redisClient *redis.Client) (Verifier, error) {
+	switch cfg.Provider {
+	case ProviderRecaptcha, "":
+		return &siteVerifier{
+			provider:      ProviderRecaptcha,
+			siteKey:       cfg.Recaptcha.SiteKey,
+			secret:        cfg.Recaptcha.Secret,
+			verifyURL:     RecaptchaURL,
+			scriptURL:     RecaptchaScriptURL,
+			responseField: GRecaptchaResponse,
+		}, nil
+	case ProviderRecaptchaV3:
+		minScore := cfg.Recaptcha.MinScore
+		if minScore <= 0 {
+			minScore = DefaultRecaptchaMinScore
+		}
+		return &siteVerifier{
+			provider:      ProviderRecaptchaV3,
+			siteKey:       cfg.Recaptcha.SiteKey,
+			secret:        cfg.Recaptcha.Secret,
+			verifyURL:     RecaptchaURL,
+			scriptURL:     RecaptchaScriptURL + "?render=" + url.QueryEscape(cfg.Recaptcha.SiteKey),
+			responseField: GRecaptchaResponse,
+			scored:        true,
+			minScore:      minScore,
+			action:        cfg.Recaptcha.Action,
+		}, nil
+	case ProviderHCaptcha:
+		return &siteVerifier{
+			provider:      ProviderHCaptcha,
+			siteKey:       cfg.HCaptcha.SiteKey,
+			secret:        cfg.HCaptcha.Secret,
+			verifyURL:     HCaptchaURL,
+			scriptURL:     HCaptchaScriptURL,
+			responseField: HCaptchaResponse,
+		}, nil
+	case ProviderTurnstile:
+		return &siteVerifier{
+			provider:      ProviderTurnstile,
+			siteKey:       cfg.Turnstile.SiteKey,
+			secret:        cfg.Turnstile.Secret,
+			verifyURL:     TurnstileURL,
+			scriptURL:     TurnstileScriptURL,
+			responseField: TurnstileResponse,
+		}, nil
+	case ProviderLocal:
+		if cfg.Local.Secret == "" {
+			return nil, errors.New("local captcha secret not specified")
+		}
+		return &localVerifier{
+			secret:   []byte(cfg.Local.Secret),
+			image:    cfg.Local.Image,
+			expire:   time.Duration(cfg.ExpireSecs) * time.Second,
+			useNonce: redisNonceUser(redisClient),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown captcha provider: %q", cfg.Provider)
+}
+
+// siteVerifier verifies responses with a siteverify API, which is shared by
+// reCAPTCHA, hCaptcha and Turnstile.
+type siteVerifier struct {
+	provider      string
+	siteKey       string
+	secret        string
+	verifyURL     string
+	scriptURL     string
+	responseField string
+
+	// For reCAPTCHA v3.
+	scored   bool
+	minScore float64
+	action   string
+
+	client *http.Client
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      float64  `json:"score"`
+	Action     string   `json:"action"`
+	Hostname   string   `json:"hostname"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (v *siteVerifier) SetupPage(p *page.Captcha) error {
+	p.Provider = v.provider
+	p.SiteKey = v.siteKey
+	p.ScriptURL = v.scriptURL
+	p.ResponseField = v.responseField
+	p.Action = v.action
+	if v.provider == ProviderRecaptcha || v.provider == ProviderRecaptchaV3 {
+		p.RecaptchaSiteKey = v.siteKey
+	}
+	return nil
+}
+
+func (v *siteVerifier) Verify(r *http.Request) error {
+	response := r.PostFormValue(v.responseField)
+	if response == "" {
+		return errNoResponse
+	}
+
+	client := v.client
+	if client == nil {
+		client = &http.Client{Timeout: SiteVerifyTimeout}
+	}
+	resp, err := client.PostForm(v.verifyURL, url.Values{
+		"secret":   {v.secret},
+		"response": {response},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("siteverify: %v", resp.Status)
+	}
+
+	var vr siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&vr); err != nil {
+		return err
+	}
+	if !vr.Success {
+		return fmt.Errorf("verification failed: %v", vr.ErrorCodes)
+	}
+	if v.scored {
+		if vr.Score < v.minScore {
+			return fmt.Errorf("verification failed: score %v", vr.Score)
+		}
+		if v.action != "" && vr.Action != v.action {
+			return fmt.Errorf("verification failed: action %q", vr.Action)
+		}
+	}
+	return nil
+}
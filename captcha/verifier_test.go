@@ -0,0 +1,154 @@
+package captcha
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ptt/pttweb/page"
+)
+
+func postForm(values url.Values) *http.Request {
+	r := httptest.NewRequest("POST", "/captcha", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// memNonceUser is an in-memory replacement of redisNonceUser.
+func memNonceUser() func(string, time.Time) (bool, error) {
+	used := make(map[string]bool)
+	return func(nonce string, expire time.Time) (bool, error) {
+		if used[nonce] {
+			return false, nil
+		}
+		used[nonce] = true
+		return true, nil
+	}
+}
+
+func TestLocalVerifier(t *testing.T) {
+	v := &localVerifier{secret: []byte("secret"), useNonce: memNonceUser()}
+	p := page.Captcha{Handle: "handle"}
+	if err := v.SetupPage(&p); err != nil {
+		t.Fatal(err)
+	}
+	var a, b int
+	var op string
+	if _, err := fmt.Sscanf(p.LocalQuestion, "%d %s %d = ?", &a, &op, &b); err != nil {
+		t.Fatalf("bad question %q: %v", p.LocalQuestion, err)
+	}
+	answer := a + b
+	switch op {
+	case "-":
+		answer = a - b
+	case "x":
+		answer = a * b
+	}
+
+	if err := v.Verify(postForm(url.Values{})); err != errNoResponse {
+		t.Error("expected no response, got", err)
+	}
+	if err := v.Verify(postForm(url.Values{
+		CaptchaHandle:  {"handle"},
+		LocalAnswer:    {fmt.Sprint(answer + 1)},
+		LocalChallenge: {p.LocalChallenge},
+	})); err != errLocalWrongAnswer {
+		t.Error("expected wrong answer, got", err)
+	}
+
+	// Challenges are bound to the handle.
+	if err := v.Verify(postForm(url.Values{
+		CaptchaHandle:  {"other"},
+		LocalAnswer:    {fmt.Sprint(answer)},
+		LocalChallenge: {p.LocalChallenge},
+	})); err != errLocalWrongAnswer {
+		t.Error("expected wrong answer with another handle, got", err)
+	}
+
+	if err := v.Verify(postForm(url.Values{
+		CaptchaHandle:  {"handle"},
+		LocalAnswer:    {fmt.Sprint(answer)},
+		LocalChallenge: {p.LocalChallenge},
+	})); err != nil {
+		t.Error("verify:", err)
+	}
+
+	// Challenges are single-use.
+	if err := v.Verify(postForm(url.Values{
+		CaptchaHandle:  {"handle"},
+		LocalAnswer:    {fmt.Sprint(answer)},
+		LocalChallenge: {p.LocalChallenge},
+	})); err != errLocalChallengeUsed {
+		t.Error("expected used challenge, got", err)
+	}
+
+	// Challenges are bound to the secret.
+	other := &localVerifier{secret: []byte("other"), useNonce: memNonceUser()}
+	if err := other.Verify(postForm(url.Values{
+		CaptchaHandle:  {"handle"},
+		LocalAnswer:    {fmt.Sprint(answer)},
+		LocalChallenge: {p.LocalChallenge},
+	})); err == nil {
+		t.Error("expected failure with another secret")
+	}
+}
+
+func TestLocalVerifierImage(t *testing.T) {
+	v := &localVerifier{secret: []byte("secret"), image: true}
+	var p page.Captcha
+	if err := v.SetupPage(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.LocalQuestion != "" || !strings.HasPrefix(string(p.LocalImage), "data:image/png;base64,") {
+		t.Errorf("unexpected question %q and image %.40q", p.LocalQuestion, p.LocalImage)
+	}
+}
+
+func TestSiteVerifierScore(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "secret" {
+			fmt.Fprint(w, `{"success":false,"error-codes":["invalid-input-secret"]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"success":true,"score":%v,"action":"captcha"}`, r.PostFormValue("response"))
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		Provider: ProviderRecaptchaV3,
+		Recaptcha: RecaptchaConfig{
+			SiteKey: "site",
+			Secret:  "secret",
+			Action:  "captcha",
+		},
+	}
+	vv, err := newVerifier(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vv.(*siteVerifier)
+	v.verifyURL = ts.URL
+
+	for _, tc := range []struct {
+		response string
+		ok       bool
+	}{
+		{"0.9", true},
+		{"0.5", true},
+		{"0.1", false},
+	} {
+		err := v.Verify(postForm(url.Values{GRecaptchaResponse: {tc.response}}))
+		if (err == nil) != tc.ok {
+			t.Errorf("score %v: got err %v, want ok %v", tc.response, err, tc.ok)
+		}
+	}
+
+	v.secret = "wrong"
+	if err := v.Verify(postForm(url.Values{GRecaptchaResponse: {"0.9"}})); err == nil {
+		t.Error("expected failure with wrong secret")
+	}
+}
@@ -0,0 +1,51 @@
+// Command manexporturl prints a signed url to export the man tree of a board,
+// for boards not in ManExportBoards of pttweb.
+//
+// The secret is read from the file given by -secret-file, or else from the
+// environment variable MANEXPORT_SECRET, so it doesn't show up in the
+// process list.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ptt/pttweb/manexport"
+)
+
+var (
+	site       = flag.String("site", "https://www.ptt.cc", "site prefix of pttweb")
+	secretFile = flag.String("secret-file", "", "file of ManExportSecret of pttweb; $MANEXPORT_SECRET if empty")
+	brdname    = flag.String("board", "", "board to export")
+	ttl        = flag.Duration("ttl", 24*time.Hour, "validity of the url")
+)
+
+func readSecret() (string, error) {
+	if *secretFile == "" {
+		return os.Getenv("MANEXPORT_SECRET"), nil
+	}
+	data, err := ioutil.ReadFile(*secretFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func main() {
+	flag.Parse()
+	secret, err := readSecret()
+	if err != nil {
+		log.Fatal("cannot read secret:", err)
+	}
+	if secret == "" || *brdname == "" {
+		log.Fatal("a secret and -board are required")
+	}
+	q := manexport.Sign(secret, *brdname, time.Now().Add(*ttl))
+	fmt.Printf("%v/man/%v/export.zip?%v\n", strings.TrimSuffix(*site, "/"), url.PathEscape(*brdname), q.Encode())
+}
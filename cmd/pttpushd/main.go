@@ -0,0 +1,178 @@
+// Command pttpushd watches boards on boardd and publishes signed push stream
+// notifications when posts are added or articles grow, e.g. by new pushes.
+// Notifications go to the publisher location of nginx push-stream module, or
+// to /stream/publish of pttweb with EnableBuiltinPushStream.
+//
+// Secrets are read from files given by flags, or else from the environment
+// variables PTTPUSHD_SECRET, PTTPUSHD_KEY and PTTPUSHD_PUBLISH_SECRET, so they
+// don't show up in the process list. The publish secret is
+// PushStreamPublishSecret of pttweb, and unused for nginx.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ptt/pttweb/pttbbs"
+	"github.com/ptt/pttweb/pushstream"
+)
+
+var (
+	boarddAddr = flag.String("boardd", "localhost:5150", "boardd address")
+	boards     = flag.String("boards", "", "comma separated boards to watch")
+	publishURL = flag.String("publish", "http://localhost/pub", "url to publish notifications to")
+	interval   = flag.Duration("interval", 5*time.Second, "interval to check for changes")
+	numRecent  = flag.Int("recent", 20, "number of latest posts per board to follow")
+
+	secretFile = flag.String("secret-file", "", "file of push stream shared secret; $PTTPUSHD_SECRET if empty")
+	keyID      = flag.String("key-id", "", "id of HMAC key to sign with; sign with the shared secret if empty")
+	keyFile    = flag.String("key-file", "", "file of HMAC key to sign with; $PTTPUSHD_KEY if empty")
+	ttl        = flag.Duration("ttl", pushstream.DefaultSignatureTTL, "validity of signatures")
+
+	publishSecretFile = flag.String("publish-secret-file", "", "file of secret to sign publish requests to pttweb with; $PTTPUSHD_PUBLISH_SECRET if empty")
+)
+
+// readSecret reads a secret from file, ignoring surrounding spaces, or from
+// the environment variable env if file is empty.
+func readSecret(file, env string) (string, error) {
+	if file == "" {
+		return os.Getenv(env), nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// article is the last seen state of an article.
+type article struct {
+	modified time.Time
+	size     int
+}
+
+type tailer struct {
+	ptt      pttbbs.Pttbbs
+	notifier *pushstream.Notifier
+
+	// Nothing is published on the first check of a board.
+	numPosts map[string]int
+	articles map[string]map[string]article
+}
+
+func (t *tailer) check(brdname string) error {
+	brd, err := pttbbs.OneBoard(t.ptt.GetBoards(pttbbs.BoardRefByName(brdname)))
+	if err != nil {
+		return err
+	}
+	prevNumPosts, seen := t.numPosts[brdname]
+	t.numPosts[brdname] = brd.NumPosts
+	if seen && brd.NumPosts != prevNumPosts {
+		if err := t.notifier.NotifyBoard(brd.BrdName, brd.NumPosts); err != nil {
+			return err
+		}
+	}
+
+	offset := brd.NumPosts - *numRecent
+	if offset < 0 {
+		offset = 0
+	}
+	list, err := t.ptt.GetArticleList(brd.Ref(), offset, *numRecent)
+	if err != nil {
+		return err
+	}
+
+	prev := t.articles[brdname]
+	curr := make(map[string]article)
+	for _, a := range list {
+		last, ok := prev[a.FileName]
+		if ok && a.Modified.Equal(last.modified) {
+			curr[a.FileName] = last
+			continue
+		}
+		// Only the size and consistency token are needed.
+		p, err := t.ptt.GetArticleSelect(brd.Ref(), pttbbs.SelectTail, a.FileName, "", -1, 1)
+		if err != nil {
+			log.Println("check article:", brdname, a.FileName, err)
+			continue
+		}
+		curr[a.FileName] = article{modified: a.Modified, size: p.FileSize}
+		if !ok || !seen || p.FileSize == last.size {
+			continue
+		}
+		if err := t.notifier.NotifyArticle(brd.BrdName, a.FileName, p.CacheKey, int64(p.FileSize)); err != nil {
+			return err
+		}
+	}
+	t.articles[brdname] = curr
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	var names []string
+	for _, b := range strings.Split(*boards, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			names = append(names, b)
+		}
+	}
+	if len(names) == 0 {
+		log.Fatal("no boards to watch")
+	}
+	secret, err := readSecret(*secretFile, "PTTPUSHD_SECRET")
+	if err != nil {
+		log.Fatal("cannot read shared secret:", err)
+	}
+	if secret == "" {
+		log.Fatal("shared secret not specified")
+	}
+	key, err := readSecret(*keyFile, "PTTPUSHD_KEY")
+	if err != nil {
+		log.Fatal("cannot read key:", err)
+	}
+	if *keyID != "" && key == "" {
+		log.Fatal("key not specified for key id:", *keyID)
+	}
+	publishSecret, err := readSecret(*publishSecretFile, "PTTPUSHD_PUBLISH_SECRET")
+	if err != nil {
+		log.Fatal("cannot read publish secret:", err)
+	}
+
+	ptt, err := pttbbs.NewGrpcRemotePtt(*boarddAddr)
+	if err != nil {
+		log.Fatal("cannot connect to boardd:", *boarddAddr, err)
+	}
+
+	keys := &pushstream.Keys{
+		LegacySecret: secret,
+		TTL:          *ttl,
+	}
+	if *keyID != "" {
+		keys.ActiveKeyID = *keyID
+		keys.Secrets = map[string]string{*keyID: key}
+	}
+
+	t := &tailer{
+		ptt: ptt,
+		notifier: &pushstream.Notifier{
+			Secret:    secret,
+			Keys:      keys,
+			Publisher: &pushstream.HTTPPublisher{URL: *publishURL, Secret: publishSecret},
+		},
+		numPosts: make(map[string]int),
+		articles: make(map[string]map[string]article),
+	}
+	for {
+		for _, brdname := range names {
+			if err := t.check(brdname); err != nil {
+				log.Println("check board:", brdname, err)
+			}
+		}
+		time.Sleep(*interval)
+	}
+}
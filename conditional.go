@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ptt/pttweb/atomfeed"
+	"github.com/ptt/pttweb/pttbbs"
+)
+
+// etagSeed is the version of templates, so that changes of templates
+// invalidate validators given out before. See setupETagSeed.
+var etagSeed string
+
+// setupETagSeed sets etagSeed to TemplateVersion, or else to a hash of the
+// template directory, so that all processes serving the same templates give
+// out the same validators.
+func setupETagSeed() error {
+	if config.TemplateVersion != "" {
+		etagSeed = config.TemplateVersion
+		return nil
+	}
+	h := fnv.New64a()
+	err := filepath.Walk(config.TemplateDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%v\x00", path)
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	etagSeed = strconv.FormatUint(h.Sum64(), 36)
+	return nil
+}
+
+// makeETag returns a strong entity tag derived from parts.
+func makeETag(parts ...string) string {
+	return `"` + strconv.FormatUint(fastStrHash64(etagSeed+"/"+strings.Join(parts, "/")), 36) + `"`
+}
+
+// checkNotModified sets validators and Cache-Control on the response. It
+// returns true after responding 304 Not Modified if the client copy is
+// still fresh, in which case the caller should not write a body. A zero
+// modified time omits Last-Modified. Responses depending on the over18
+// cookie must be private, so that shared caches don't serve them to others.
+func checkNotModified(c *Context, w http.ResponseWriter, etag string, modified time.Time, maxAge time.Duration, private bool) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	cc := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	if private {
+		cc = "private, " + cc
+		h.Add("Vary", "Cookie")
+	}
+	h.Set("Cache-Control", cc)
+
+	if c.R.Method != "GET" && c.R.Method != "HEAD" {
+		return false
+	}
+	if !isNotModified(c.R, etag, modified) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func isNotModified(r *http.Request, etag string, modified time.Time) bool {
+	// If-None-Match takes precedence over If-Modified-Since.
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagListMatches reports whether the If-None-Match header value matches
+// etag using the weak comparison.
+func etagListMatches(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func lastArticleModified(lists ...[]pttbbs.Article) time.Time {
+	var t time.Time
+	for _, articles := range lists {
+		for _, a := range articles {
+			if a.Modified.After(t) {
+				t = a.Modified
+			}
+		}
+	}
+	return t
+}
+
+func feedModified(feed *atomfeed.Feed) time.Time {
+	t := feed.Updated
+	for _, e := range feed.Entries {
+		if e.Updated.After(t) {
+			t = e.Updated
+		}
+	}
+	return t
+}
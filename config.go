@@ -2,10 +2,19 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
 
+	"github.com/ptt/pttweb/article"
 	"github.com/ptt/pttweb/captcha"
 	"github.com/ptt/pttweb/experiment"
 	"github.com/ptt/pttweb/extcache"
+	"github.com/ptt/pttweb/pttbbs"
+	"github.com/ptt/pttweb/pushstream"
+	"github.com/ptt/pttweb/ratelimit"
+	"github.com/ptt/pttweb/richcontent"
 )
 
 type PttwebConfig struct {
@@ -16,10 +25,42 @@ type PttwebConfig struct {
 	MemcachedAddress  string
 	TemplateDirectory string
 	StaticPrefix      string
-	SitePrefix        string
+
+	// TemplateVersion is part of entity tags, and should change with
+	// templates. It defaults to a hash of TemplateDirectory.
+	TemplateVersion string
+	SitePrefix      string
 
 	MemcachedMaxConn int
 
+	// Concurrent calls to each backend are limited to MaxConn, and up to
+	// MaxWait more calls may wait. Calls beyond are rejected with a server
+	// too busy page.
+	BoarddMaxConn int
+	BoarddMaxWait int
+	SearchMaxConn int
+	SearchMaxWait int
+	MandMaxConn   int
+	MandMaxWait   int
+
+	// Calls to mand time out after MandTimeoutSecs. After
+	// MandBreakerThreshold calls in a row fail, mand is considered down and
+	// calls fail fast for MandBreakerCooldownSecs.
+	MandTimeoutSecs         int
+	MandBreakerThreshold    int
+	MandBreakerCooldownSecs int
+
+	// Man trees of ManExportBoards can be downloaded at
+	// /man/{brdname}/export.zip. Other boards need urls signed with
+	// ManExportSecret, see cmd/manexporturl.
+	ManExportBoards     []string
+	ManExportSecret     string
+	ManExportMaxEntries int
+	ManExportMaxBytes   int64
+
+	// EnableGateStats serves stats of the limits above at /debug/gates.
+	EnableGateStats bool
+
 	GAAccount string
 	GADomain  string
 
@@ -29,21 +70,93 @@ type PttwebConfig struct {
 	// ALLPOST board and link to original posts.
 	EnableLinkOriginalInAllPost bool
 
+	// UserProfileBoards are searched for posts in user profile pages, in
+	// addition to hot boards.
+	UserProfileBoards []string
+
 	FeedPrefix            string
 	AtomFeedTitleTemplate string
+	HotboardsFeedTitle    string
+
+	// FeedFullContent indicates whether feed entries contain the full
+	// rendered article instead of a snippet. FeedMaxContentSize caps the
+	// size of each entry in bytes.
+	FeedFullContent    bool
+	FeedMaxContentSize int
 
 	EnablePushStream            bool
 	PushStreamSharedSecret      string
 	PushStreamSubscribeLocation string
 
+	// PushStreamKeys maps key ids to secrets for signing offsets with
+	// HMAC-SHA256, and PushStreamActiveKeyID selects the one to sign with.
+	// Without an active key, offsets are signed with PushStreamSharedSecret
+	// as before. Such legacy signatures are still accepted until
+	// PushStreamAcceptLegacyUntil after switching.
+	PushStreamKeys              map[string]string
+	PushStreamActiveKeyID       string
+	PushStreamSignatureTTLSecs  int
+	PushStreamAcceptLegacyUntil time.Time
+
+	// EnableBuiltinPushStream serves live article updates over Server-Sent
+	// Events and WebSocket without an external push-stream server. It
+	// requires EnablePushStream.
+	EnableBuiltinPushStream bool
+	// PushStreamPublishSecret authenticates publishers to /stream/publish of
+	// the builtin push stream, which is disabled if it's empty.
+	PushStreamPublishSecret string
+
+	// CaptchaProvider is one of "recaptcha" (default), "recaptcha_v3",
+	// "hcaptcha", "turnstile" and "local".
+	CaptchaProvider     string
 	RecaptchaSiteKey    string
 	RecaptchaSecret     string
+	RecaptchaMinScore   float64
+	RecaptchaAction     string
+	HCaptchaSiteKey     string
+	HCaptchaSecret      string
+	TurnstileSiteKey    string
+	TurnstileSecret     string
+	CaptchaLocalSecret  string
+	CaptchaLocalImage   bool
 	CaptchaInsertSecret string
 	CaptchaExpireSecs   int
 	CaptchaRedisConfig  *captcha.RedisConfig
 
+	// CaptchaInsertSecrets are accepted in addition to CaptchaInsertSecret
+	// for rotation.
+	CaptchaInsertSecrets          []string
+	CaptchaMaxInsertSkewSecs      int
+	CaptchaAllowPlainInsertSecret bool
+
+	CaptchaMaxAttemptsPerHandle int
+	CaptchaMaxAttemptsPerIP     int
+	CaptchaAttemptWindowSecs    int
+
 	ExtCacheConfig extcache.Config
 
+	// EmbedProviders embed urls they match, before the builtin ones, e.g.
+	// YouTube and images. OEmbedProvidersFile lists oEmbed providers in the
+	// format of https://oembed.com/providers.json, tried after the builtin
+	// ones. Only providers marked Trusted may embed html of oEmbed as is.
+	EmbedProviders      []richcontent.ProviderConfig
+	OEmbedProvidersFile string
+
+	// RateLimitConfig limits request rates by client IP. Budgets of routes
+	// default to DefaultRateLimitRoutes. With RateLimitCaptchaRedirect,
+	// limited visitors may solve a captcha to be exempted. Its
+	// TrustedProxies also find client IPs of captcha attempts, even if rate
+	// limiting is disabled.
+	RateLimitConfig          ratelimit.Config
+	RateLimitCaptchaRedirect bool
+
+	// RenderProfiles are named sets of rendering options of articles.
+	// Boards use the profile named in BoardRenderProfiles by board name,
+	// or else in ClassRenderProfiles by board class, e.g. art boards.
+	RenderProfiles      map[string]RenderProfile
+	BoardRenderProfiles map[string]string
+	ClassRenderProfiles map[string]string
+
 	Experiments Experiments
 }
 
@@ -51,11 +164,73 @@ type Experiments struct {
 	ExtCache experiment.OptIn
 }
 
+// RenderProfile tunes rendering of articles of a board.
+type RenderProfile struct {
+	// DisableEmbeds keeps links, but not images and videos after them.
+	DisableEmbeds bool
+	// DisableLinks renders urls and article ids as plain text.
+	DisableLinks         bool
+	DisablePushDetection bool
+	// WrapColumns breaks lines wider than this, e.g. 80 for exact layout
+	// of art. Zero disables wrapping.
+	WrapColumns int
+}
+
+func (p RenderProfile) options() []article.RenderOption {
+	var opts []article.RenderOption
+	if p.DisableEmbeds {
+		opts = append(opts, article.WithDisableEmbeds())
+	}
+	if p.DisableLinks {
+		opts = append(opts, article.WithDisableLinks())
+	}
+	if p.DisablePushDetection {
+		opts = append(opts, article.WithDisablePushDetection())
+	}
+	if p.WrapColumns > 0 {
+		opts = append(opts, article.WithWrapColumns(p.WrapColumns))
+	}
+	return opts
+}
+
 const (
 	DefaultBoarddMaxConn    = 16
 	DefaultMemcachedMaxConn = 16
+	DefaultSearchMaxConn    = 4
+	DefaultMandMaxConn      = 8
+
+	DefaultMandTimeoutSecs         = 5
+	DefaultMandBreakerThreshold    = 5
+	DefaultMandBreakerCooldownSecs = 30
+
+	DefaultManExportMaxEntries = 5000
+	DefaultManExportMaxBytes   = 256 * 1024 * 1024
+
+	// Backends have this many times of MaxConn waiting by default.
+	DefaultMaxWaitFactor = 4
+
+	DefaultFeedMaxContentSize = 64 * 1024
+	DefaultHotboardsFeedTitle = "熱門看板"
+
+	// Signed offsets are embedded in cached pages, so they must outlive
+	// ArticleCacheTimeout by far.
+	DefaultPushStreamSignatureTTLSecs = 6 * 60 * 60
 )
 
+// DefaultRateLimitRoutes are budgets of routes by name. Routes hitting
+// backends without cache are stricter.
+var DefaultRateLimitRoutes = map[string]ratelimit.Budget{
+	"bbssearch":       {Rate: 0.2, Burst: 10},
+	"userprofile":     {Rate: 0.1, Burst: 5},
+	"mansearch":       {Rate: 0.1, Burst: 5},
+	"manexport":       {Rate: 0.002, Burst: 3},
+	"bbsarticlepoll":  {Rate: 0.5, Burst: 30},
+	"manarticlepoll":  {Rate: 0.5, Burst: 30},
+	"bbsarticlerange": {Rate: 1, Burst: 30},
+	"bbsarticle":      {Rate: 2, Burst: 60},
+	"captcha":         {},
+}
+
 func (c *PttwebConfig) CheckAndFillDefaults() error {
 	if c.BoarddAddress == "" {
 		return errors.New("boardd address not specified")
@@ -69,19 +244,168 @@ func (c *PttwebConfig) CheckAndFillDefaults() error {
 		c.MemcachedMaxConn = DefaultMemcachedMaxConn
 	}
 
+	fillGateDefaults(&c.BoarddMaxConn, &c.BoarddMaxWait, DefaultBoarddMaxConn)
+	fillGateDefaults(&c.SearchMaxConn, &c.SearchMaxWait, DefaultSearchMaxConn)
+	fillGateDefaults(&c.MandMaxConn, &c.MandMaxWait, DefaultMandMaxConn)
+
+	if c.MandTimeoutSecs <= 0 {
+		c.MandTimeoutSecs = DefaultMandTimeoutSecs
+	}
+	if c.MandBreakerThreshold <= 0 {
+		c.MandBreakerThreshold = DefaultMandBreakerThreshold
+	}
+	if c.MandBreakerCooldownSecs <= 0 {
+		c.MandBreakerCooldownSecs = DefaultMandBreakerCooldownSecs
+	}
+
+	if c.ManExportMaxEntries <= 0 {
+		c.ManExportMaxEntries = DefaultManExportMaxEntries
+	}
+	if c.ManExportMaxBytes <= 0 {
+		c.ManExportMaxBytes = DefaultManExportMaxBytes
+	}
+
+	if c.FeedMaxContentSize <= 0 {
+		c.FeedMaxContentSize = DefaultFeedMaxContentSize
+	}
+
+	if c.HotboardsFeedTitle == "" {
+		c.HotboardsFeedTitle = DefaultHotboardsFeedTitle
+	}
+
+	if c.PushStreamActiveKeyID != "" && c.PushStreamKeys[c.PushStreamActiveKeyID] == "" {
+		return errors.New("push stream active key not found")
+	}
+
+	if c.RateLimitConfig.Routes == nil {
+		c.RateLimitConfig.Routes = DefaultRateLimitRoutes
+	}
+
+	if c.PushStreamSignatureTTLSecs <= 0 {
+		c.PushStreamSignatureTTLSecs = DefaultPushStreamSignatureTTLSecs
+	}
+
+	if err := c.checkRenderProfiles(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *PttwebConfig) checkRenderProfiles() error {
+	for name, p := range c.RenderProfiles {
+		if p.WrapColumns < 0 {
+			return fmt.Errorf("render profile %q: negative WrapColumns", name)
+		}
+	}
+	for _, m := range []map[string]string{c.BoardRenderProfiles, c.ClassRenderProfiles} {
+		for key, name := range m {
+			if _, ok := c.RenderProfiles[name]; !ok {
+				return fmt.Errorf("render profile %q of %q not found", name, key)
+			}
+		}
+	}
 	return nil
 }
 
+func (c *PttwebConfig) renderProfileName(brd *pttbbs.Board) (string, bool) {
+	name, ok := c.BoardRenderProfiles[brd.BrdName]
+	if !ok {
+		name, ok = c.ClassRenderProfiles[strings.TrimSpace(brd.Class)]
+	}
+	return name, ok
+}
+
+// renderOptions returns rendering options of articles of the board.
+func (c *PttwebConfig) renderOptions(brd *pttbbs.Board) []article.RenderOption {
+	name, ok := c.renderProfileName(brd)
+	if !ok {
+		return nil
+	}
+	return c.RenderProfiles[name].options()
+}
+
+// renderProfileKey identifies the rendering options of articles of the
+// board in cache keys, so that rendered articles aren't served after the
+// profile is changed. It is empty for boards without a profile.
+func (c *PttwebConfig) renderProfileKey(brd *pttbbs.Board) string {
+	name, ok := c.renderProfileName(brd)
+	if !ok {
+		return ""
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%+v", c.RenderProfiles[name])
+	return fmt.Sprintf("~%v.%08x", name, h.Sum32())
+}
+
+func fillGateDefaults(maxConn, maxWait *int, defaultMaxConn int) {
+	if *maxConn <= 0 {
+		*maxConn = defaultMaxConn
+	}
+	if *maxWait <= 0 {
+		*maxWait = *maxConn * DefaultMaxWaitFactor
+	}
+}
+
+func (c *PttwebConfig) pushStreamKeys() *pushstream.Keys {
+	return &pushstream.Keys{
+		ActiveKeyID:       c.PushStreamActiveKeyID,
+		Secrets:           c.PushStreamKeys,
+		TTL:               time.Duration(c.PushStreamSignatureTTLSecs) * time.Second,
+		LegacySecret:      c.PushStreamSharedSecret,
+		AcceptLegacyUntil: c.PushStreamAcceptLegacyUntil,
+	}
+}
+
 func (c *PttwebConfig) captchaConfig() *captcha.Config {
-	enabled := c.RecaptchaSiteKey != "" && c.RecaptchaSecret != "" && c.CaptchaRedisConfig != nil
-	return &captcha.Config{
-		Enabled:      enabled,
-		InsertSecret: c.CaptchaInsertSecret,
-		ExpireSecs:   c.CaptchaExpireSecs,
+	cfg := &captcha.Config{
+		InsertSecret:           c.CaptchaInsertSecret,
+		InsertSecrets:          c.CaptchaInsertSecrets,
+		MaxInsertSkewSecs:      c.CaptchaMaxInsertSkewSecs,
+		AllowPlainInsertSecret: c.CaptchaAllowPlainInsertSecret,
+
+		ExpireSecs: c.CaptchaExpireSecs,
+		Provider:   c.CaptchaProvider,
+
+		MaxAttemptsPerHandle: c.CaptchaMaxAttemptsPerHandle,
+		MaxAttemptsPerIP:     c.CaptchaMaxAttemptsPerIP,
+		AttemptWindowSecs:    c.CaptchaAttemptWindowSecs,
+
 		Recaptcha: captcha.RecaptchaConfig{
-			SiteKey: c.RecaptchaSiteKey,
-			Secret:  c.RecaptchaSecret,
+			SiteKey:  c.RecaptchaSiteKey,
+			Secret:   c.RecaptchaSecret,
+			MinScore: c.RecaptchaMinScore,
+			Action:   c.RecaptchaAction,
+		},
+		HCaptcha: captcha.SiteVerifyConfig{
+			SiteKey: c.HCaptchaSiteKey,
+			Secret:  c.HCaptchaSecret,
+		},
+		Turnstile: captcha.SiteVerifyConfig{
+			SiteKey: c.TurnstileSiteKey,
+			Secret:  c.TurnstileSecret,
 		},
-		Redis: *c.CaptchaRedisConfig,
+		Local: captcha.LocalConfig{
+			Secret: c.CaptchaLocalSecret,
+			Image:  c.CaptchaLocalImage,
+		},
+	}
+
+	var configured bool
+	switch c.CaptchaProvider {
+	case captcha.ProviderRecaptcha, captcha.ProviderRecaptchaV3, "":
+		configured = c.RecaptchaSiteKey != "" && c.RecaptchaSecret != ""
+	case captcha.ProviderHCaptcha:
+		configured = c.HCaptchaSiteKey != "" && c.HCaptchaSecret != ""
+	case captcha.ProviderTurnstile:
+		configured = c.TurnstileSiteKey != "" && c.TurnstileSecret != ""
+	default:
+		// Let captcha.Install report bad providers.
+		configured = true
+	}
+	if configured && c.CaptchaRedisConfig != nil {
+		cfg.Enabled = true
+		cfg.Redis = *c.CaptchaRedisConfig
 	}
+	return cfg
 }
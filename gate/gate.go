@@ -2,17 +2,43 @@
 package gate
 
 import (
+	"context"
 	"sync"
 )
 
+// Priority orders waiting reservations. Reservations of higher priority are
+// granted first, and those of the same priority are granted in order.
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+
+	numPriorities = iota
+)
+
 // Gate provides concurrency limitation.
 type Gate struct {
 	maxInflight int
 	maxWait     int
 
-	mu    sync.Mutex
-	num   int
-	queue chan *Reservation
+	mu       sync.Mutex
+	inflight int
+	waiting  int
+	queues   [numPriorities][]*Reservation
+	admitted uint64
+	rejected uint64
+}
+
+// Stats is a snapshot of a Gate.
+type Stats struct {
+	MaxInflight int    `json:"maxInflight"`
+	MaxWait     int    `json:"maxWait"`
+	Inflight    int    `json:"inflight"`
+	Waiting     int    `json:"waiting"`
+	Admitted    uint64 `json:"admitted"`
+	Rejected    uint64 `json:"rejected"`
 }
 
 // New creates a Gate.
@@ -20,54 +46,139 @@ func New(maxInflight, maxWait int) *Gate {
 	return &Gate{
 		maxInflight: maxInflight,
 		maxWait:     maxWait,
-		queue:       make(chan *Reservation, maxWait),
 	}
 }
 
-// Reserve attempts to obtain a reservation. If the wait queue is too long, it
-// returns false.
+// Reserve attempts to obtain a reservation of normal priority. If the wait
+// queue is too long, it returns false.
 func (g *Gate) Reserve() (*Reservation, bool) {
+	return g.ReservePriority(PriorityNormal)
+}
+
+// ReservePriority attempts to obtain a reservation of the priority. If the
+// wait queue is too long, it returns false.
+func (g *Gate) ReservePriority(prio Priority) (*Reservation, bool) {
+	if prio < 0 || prio >= numPriorities {
+		prio = PriorityNormal
+	}
+
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if g.num >= g.maxInflight+g.maxWait {
+	if g.inflight+g.waiting >= g.maxInflight+g.maxWait {
+		g.rejected++
 		return nil, false
 	}
-	g.num++
+	g.admitted++
 
 	// Grant immediately.
-	if g.num <= g.maxInflight {
+	if g.inflight < g.maxInflight && g.waiting == 0 {
+		g.inflight++
 		return &Reservation{
-			g: g,
+			g:     g,
+			state: stateGranted,
 		}, true
 	}
 
 	// Grant later.
 	r := &Reservation{
 		g:       g,
+		prio:    prio,
 		granted: make(chan struct{}),
+		state:   stateQueued,
 	}
-	g.queue <- r
+	g.queues[prio] = append(g.queues[prio], r)
+	g.waiting++
 	return r, true
 }
 
-func (g *Gate) release() {
+// Stats returns current stats of the gate.
+func (g *Gate) Stats() Stats {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	g.num--
+	return Stats{
+		MaxInflight: g.maxInflight,
+		MaxWait:     g.maxWait,
+		Inflight:    g.inflight,
+		Waiting:     g.waiting,
+		Admitted:    g.admitted,
+		Rejected:    g.rejected,
+	}
+}
 
-	select {
-	case r := <-g.queue:
-		r.grant()
-	default:
+// dequeue removes a waiting reservation. It must be called with mu held.
+func (g *Gate) dequeue(r *Reservation) {
+	q := g.queues[r.prio]
+	for i := range q {
+		if q[i] == r {
+			g.queues[r.prio] = append(q[:i], q[i+1:]...)
+			g.waiting--
+			return
+		}
+	}
+}
+
+// grantNext grants the first waiting reservation of the highest priority. It
+// must be called with mu held.
+func (g *Gate) grantNext() {
+	for prio := range g.queues {
+		if q := g.queues[prio]; len(q) > 0 {
+			r := q[0]
+			q[0] = nil
+			g.queues[prio] = q[1:]
+			g.waiting--
+			g.inflight++
+			r.grant()
+			return
+		}
 	}
 }
 
+func (g *Gate) release(r *Reservation) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch r.state {
+	case stateQueued:
+		g.dequeue(r)
+	case stateGranted:
+		g.inflight--
+		g.grantNext()
+	}
+	r.state = stateReleased
+}
+
+// abandon gives up a waiting reservation. It returns false if the
+// reservation has been granted.
+func (g *Gate) abandon(r *Reservation) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if r.state != stateQueued {
+		return r.state == stateReleased
+	}
+	g.dequeue(r)
+	r.state = stateReleased
+	return true
+}
+
+type reservationState int
+
+const (
+	stateQueued reservationState = iota
+	stateGranted
+	stateReleased
+)
+
 // Reservation represents a reservation.
 type Reservation struct {
 	g       *Gate
+	prio    Priority
 	granted chan struct{}
+
+	// Guarded by g.mu.
+	state reservationState
 }
 
 // Wait blocks until number of inflight requests is lower than the maximum.
@@ -77,13 +188,33 @@ func (r *Reservation) Wait() {
 	}
 }
 
+// WaitContext is like Wait, but gives up the reservation and returns the
+// error of ctx if ctx is done first. Release is still fine to call after.
+func (r *Reservation) WaitContext(ctx context.Context) error {
+	if r.granted == nil {
+		return nil
+	}
+	select {
+	case <-r.granted:
+		return nil
+	case <-ctx.Done():
+		if r.g.abandon(r) {
+			return ctx.Err()
+		}
+		// Granted in the meantime.
+		return nil
+	}
+}
+
 // Release returns the reservation. It must be called when the reservation is
 // no longer needed. It doesn't matter if Wait() was called or not.
 func (r *Reservation) Release() {
-	r.g.release()
+	r.g.release(r)
 }
 
+// grant must be called with g.mu held.
 func (r *Reservation) grant() {
+	r.state = stateGranted
 	close(r.granted)
 }
 
@@ -1,6 +1,7 @@
 package gate
 
 import (
+	"context"
 	"math/rand"
 	"sync"
 	"sync/atomic"
@@ -64,6 +65,112 @@ func TestGate(t *testing.T) {
 	}
 }
 
+func TestStats(t *testing.T) {
+	g := New(2, 1)
+
+	var rs []*Reservation
+	for i := 0; i < 4; i++ {
+		if r, ok := g.Reserve(); ok {
+			rs = append(rs, r)
+		}
+	}
+
+	want := Stats{MaxInflight: 2, MaxWait: 1, Inflight: 2, Waiting: 1, Admitted: 3, Rejected: 1}
+	if got := g.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	for _, r := range rs {
+		r.Release()
+	}
+	want = Stats{MaxInflight: 2, MaxWait: 1, Admitted: 3, Rejected: 1}
+	if got := g.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	g := New(1, 2)
+
+	r0, _ := g.Reserve()
+	r1, _ := g.Reserve()
+	r2, _ := g.Reserve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r1.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// Releasing an abandoned reservation is a no-op.
+	r1.Release()
+
+	if got := g.Stats(); got.Inflight != 1 || got.Waiting != 1 {
+		t.Errorf("Stats() = %+v, want 1 inflight and 1 waiting", got)
+	}
+
+	// The abandoned slot is available again, and r2 is next in line.
+	r3, ok := g.Reserve()
+	if !ok {
+		t.Fatal("Reserve() failed after abandoning")
+	}
+	r0.Release()
+	if err := r2.WaitContext(context.Background()); err != nil {
+		t.Errorf("WaitContext() = %v, want nil", err)
+	}
+	if r3.isGranted() {
+		t.Error("r3 granted before r2 released")
+	}
+	r2.Release()
+	r3.Wait()
+	r3.Release()
+
+	if got, want := g.Stats(), (Stats{MaxInflight: 1, MaxWait: 2, Admitted: 4}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWaitContextGranted(t *testing.T) {
+	g := New(1, 1)
+	r0, _ := g.Reserve()
+	r1, _ := g.Reserve()
+	r0.Release()
+
+	// Already granted; a done context doesn't matter.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r1.WaitContext(ctx); err != nil {
+		t.Errorf("WaitContext() = %v, want nil", err)
+	}
+	r1.Release()
+	if got := g.Stats(); got.Inflight != 0 || got.Waiting != 0 {
+		t.Errorf("Stats() = %+v, want nothing inflight or waiting", got)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	g := New(1, 4)
+	r0, _ := g.Reserve()
+
+	low, _ := g.ReservePriority(PriorityLow)
+	normal, _ := g.ReservePriority(PriorityNormal)
+	high1, _ := g.ReservePriority(PriorityHigh)
+	high2, _ := g.ReservePriority(PriorityHigh)
+
+	order := []*Reservation{high1, high2, normal, low}
+	prev := r0
+	for i, r := range order {
+		prev.Release()
+		for j, other := range order {
+			if got, want := other.isGranted(), j <= i; got != want {
+				t.Errorf("after releasing #%v: #%v isGranted() = %v, want %v", i, j, got, want)
+			}
+		}
+		r.Wait()
+		prev = r
+	}
+	prev.Release()
+}
+
 func concurrentWorker(t *testing.T, wg *sync.WaitGroup, g *Gate, num *int32, max int32) {
 	defer wg.Done()
 	for j := 0; j < 1000; j++ {
@@ -9,7 +9,6 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/onsi/ginkgo v1.14.1 // indirect
 	github.com/onsi/gomega v1.10.2 // indirect
-	github.com/rvelhote/go-recaptcha v0.0.0-20170215232712-e143c6ea64e5
 	golang.org/x/net v0.0.0-20200904194848-62affa334b73
 	golang.org/x/tools v0.0.0-20200904185747-39188db58858
 	google.golang.org/grpc v1.31.1
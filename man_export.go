@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ptt/pttweb/article"
+	"github.com/ptt/pttweb/manexport"
+	"github.com/ptt/pttweb/pttbbs"
+)
+
+const (
+	// ManExportMaxArticleSize limits the size of each exported article.
+	ManExportMaxArticleSize = 4 * 1024 * 1024
+)
+
+// manExportSource reads the man tree of a board from mand.
+type manExportSource struct {
+	brd *pttbbs.Board
+}
+
+func (s *manExportSource) Boardname() string {
+	return s.brd.BrdName
+}
+
+func (s *manExportSource) List(ctx context.Context, dir string) ([]manexport.Entry, error) {
+	mi, err := getManIndex(s.brd, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !mi.IsValid {
+		return nil, fmt.Errorf("not a valid man index: %v/%v", s.brd.BrdName, dir)
+	}
+	entries := make([]manexport.Entry, len(mi.Entries))
+	for i, e := range mi.Entries {
+		entries[i] = manexport.Entry{
+			Path:  e.Path,
+			Title: e.Title,
+			IsDir: e.IsDir,
+		}
+	}
+	return entries, nil
+}
+
+func (s *manExportSource) Article(ctx context.Context, p string) ([]byte, bool, error) {
+	part, err := manArticleSelect(ctx, s.brd, p, pttbbs.SelectPart, "", 0, ManExportMaxArticleSize)
+	if err != nil {
+		return nil, false, err
+	}
+	return part.Content, part.Offset+part.Length < part.FileSize, nil
+}
+
+func (s *manExportSource) render(ctx context.Context, w io.Writer, content []byte) error {
+	opts := append([]article.RenderOption{
+		article.WithContent(content),
+		article.WithContext(context.WithValue(context.WithValue(ctx, CtxKeyBoardname, s), CtxKeyAbsoluteLinks, true)),
+	}, config.renderOptions(s.brd)...)
+	_, err := article.RenderTo(w, opts...)
+	return err
+}
+
+// canExportMan reports whether the man tree of the board may be exported,
+// either by the allowlist or a signed url.
+func canExportMan(r *http.Request, brdname string) bool {
+	for _, b := range config.ManExportBoards {
+		if strings.EqualFold(b, brdname) {
+			return true
+		}
+	}
+	return manexport.Verify(config.ManExportSecret, brdname, r.URL.Query(), time.Now()) == nil
+}
+
+func handleManExport(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	brd, err := getBoardByName(c, vars["brdname"])
+	if err != nil {
+		return err
+	}
+	if !canExportMan(c.R, brd.BrdName) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+
+	// Check the root before the archive is sent, so errors get proper pages.
+	mi, err := getManIndex(brd, "")
+	if err != nil {
+		return err
+	}
+	if !mi.IsValid {
+		return NewNotFoundError(fmt.Errorf("not a valid man index: %v", brd.BrdName))
+	}
+
+	src := &manExportSource{brd: brd}
+	e := &manexport.Exporter{
+		Brdname: brd.BrdName,
+		Source:  src,
+		Render:  src.render,
+		Limits: manexport.Limits{
+			MaxEntries: config.ManExportMaxEntries,
+			MaxBytes:   config.ManExportMaxBytes,
+		},
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v-man.zip"`, brd.BrdName))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	res, err := e.WriteZip(c.R.Context(), w)
+	if err != nil {
+		// The archive is partially sent, nothing better to do.
+		log.Println("handleManExport:", brd.BrdName, err)
+		return nil
+	}
+	if res.Err != nil {
+		log.Println("handleManExport: incomplete:", brd.BrdName, res.Err)
+	}
+	return nil
+}
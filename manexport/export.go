@@ -0,0 +1,319 @@
+// Package manexport writes a man tree of a board into a zip archive.
+package manexport
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEntryLimit indicates the tree has more entries than allowed.
+	ErrEntryLimit = errors.New("too many man entries")
+	// ErrSizeLimit indicates the articles are larger than allowed.
+	ErrSizeLimit = errors.New("man articles too large")
+)
+
+// Entry is an entry of a man directory. Path is relative to the board.
+type Entry struct {
+	Path  string
+	Title string
+	IsDir bool
+}
+
+// Source reads a man tree.
+type Source interface {
+	// List returns entries of a directory. The root directory is "".
+	List(ctx context.Context, dir string) ([]Entry, error)
+	// Article returns raw content of an article, and whether it is cut
+	// short, e.g. for being too large.
+	Article(ctx context.Context, p string) (content []byte, truncated bool, err error)
+}
+
+// RenderFunc renders raw content of an article into HTML written to w.
+type RenderFunc func(ctx context.Context, w io.Writer, content []byte) error
+
+// Limits bounds an export. Zero values are unlimited.
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// Exporter writes the man tree of a board.
+type Exporter struct {
+	Brdname string
+	Source  Source
+	Render  RenderFunc
+	Limits  Limits
+}
+
+// Result summarizes an export.
+type Result struct {
+	Entries int
+	Bytes   int64
+	// Truncated is the number of articles cut short.
+	Truncated int
+	// Err is why the export stopped early, e.g. a limit.
+	Err error
+}
+
+// node is a directory or an article in the tree.
+type node struct {
+	Entry
+	Children []*node
+	// Exported is set if files of the article are written.
+	Exported bool
+	// Truncated is set if the article is cut short.
+	Truncated bool
+}
+
+type walker struct {
+	e   *Exporter
+	zw  *zip.Writer
+	res *Result
+	// flusher, if not nil, sends what's written so far to the client.
+	flusher flusher
+}
+
+// flusher is implemented by e.g. http.Flusher.
+type flusher interface {
+	Flush()
+}
+
+// WriteZip walks the tree and writes each article as raw content (.ansi) and
+// rendered HTML (.html), followed by index.html of the whole tree. Files
+// are under a directory named after the board.
+//
+// The root directory is listed before anything is written, so its error can
+// still be reported to the client. Afterwards, failures stop the walk and
+// are recorded in the result and index.html, as the archive is partially
+// sent already. Only errors writing to w are returned then.
+//
+// If w has a Flush method, e.g. an http.ResponseWriter, it is flushed after
+// each article, so that the archive is sent progressively as articles are
+// rendered.
+func (e *Exporter) WriteZip(ctx context.Context, w io.Writer) (*Result, error) {
+	rootEntries, err := e.Source.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	wk := &walker{
+		e:   e,
+		zw:  zip.NewWriter(w),
+		res: new(Result),
+	}
+	wk.flusher, _ = w.(flusher)
+	root := &node{Entry: Entry{Title: e.Brdname, IsDir: true}}
+	if err := wk.walk(ctx, root, rootEntries); err != nil {
+		se, ok := err.(stopError)
+		if !ok {
+			return nil, err
+		}
+		wk.res.Err = se.error
+	}
+	if err := wk.writeIndex(root); err != nil {
+		return nil, err
+	}
+	if err := wk.zw.Close(); err != nil {
+		return nil, err
+	}
+	return wk.res, nil
+}
+
+// stopError wraps errors stopping the walk but not the archive.
+type stopError struct {
+	error
+}
+
+func (wk *walker) walk(ctx context.Context, dir *node, entries []Entry) error {
+	for _, ent := range entries {
+		p, ok := cleanPath(ent.Path)
+		if !ok {
+			log.Println("manexport: skipping bad path:", wk.e.Brdname, ent.Path)
+			continue
+		}
+		ent.Path = p
+		if err := ctx.Err(); err != nil {
+			return stopError{err}
+		}
+		if max := wk.e.Limits.MaxEntries; max > 0 && wk.res.Entries >= max {
+			return stopError{ErrEntryLimit}
+		}
+		wk.res.Entries++
+
+		n := &node{Entry: ent}
+		dir.Children = append(dir.Children, n)
+		if ent.IsDir {
+			sub, err := wk.e.Source.List(ctx, ent.Path)
+			if err != nil {
+				return stopError{fmt.Errorf("list %v: %w", ent.Path, err)}
+			}
+			if err := wk.walk(ctx, n, sub); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := wk.writeArticle(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wk *walker) writeArticle(ctx context.Context, n *node) error {
+	content, truncated, err := wk.e.Source.Article(ctx, n.Path)
+	if err != nil {
+		return stopError{fmt.Errorf("article %v: %w", n.Path, err)}
+	}
+	if max := wk.e.Limits.MaxBytes; max > 0 && wk.res.Bytes+int64(len(content)) > max {
+		return stopError{ErrSizeLimit}
+	}
+	wk.res.Bytes += int64(len(content))
+	if truncated {
+		n.Truncated = true
+		wk.res.Truncated++
+	}
+
+	if err := wk.writeFile(n.Path+".ansi", content); err != nil {
+		return err
+	}
+	f, err := wk.create(n.Path + ".html")
+	if err != nil {
+		return err
+	}
+	// Articles are rendered into the archive directly, without holding
+	// the whole HTML.
+	w := &errWriter{w: f}
+	if err := articleTmpl.ExecuteTemplate(w, "head", map[string]interface{}{
+		"Title": n.Title,
+		"Raw":   path.Base(n.Path) + ".ansi",
+		"Index": relRoot(n.Path) + "index.html",
+	}); err != nil {
+		return err
+	}
+	if err := wk.e.Render(ctx, w, content); err != nil {
+		if w.err != nil {
+			return w.err
+		}
+		return stopError{fmt.Errorf("render %v: %w", n.Path, err)}
+	}
+	if err := articleTmpl.ExecuteTemplate(w, "foot", nil); err != nil {
+		return err
+	}
+	n.Exported = true
+	return wk.flush()
+}
+
+func (wk *walker) flush() error {
+	if wk.flusher == nil {
+		return nil
+	}
+	if err := wk.zw.Flush(); err != nil {
+		return err
+	}
+	wk.flusher.Flush()
+	return nil
+}
+
+// errWriter remembers errors of w, to tell them apart from rendering errors.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (wk *walker) writeIndex(root *node) error {
+	f, err := wk.create("index.html")
+	if err != nil {
+		return err
+	}
+	var errMsg string
+	if wk.res.Err != nil {
+		errMsg = wk.res.Err.Error()
+	}
+	return indexTmpl.Execute(f, map[string]interface{}{
+		"Brdname": wk.e.Brdname,
+		"Root":    root,
+		"Result":  wk.res,
+		"ErrMsg":  errMsg,
+	})
+}
+
+func (wk *walker) create(name string) (io.Writer, error) {
+	return wk.zw.CreateHeader(&zip.FileHeader{
+		Name:     path.Join(wk.e.Brdname, name),
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+func (wk *walker) writeFile(name string, data []byte) error {
+	f, err := wk.create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// cleanPath normalizes p, and rejects paths escaping the board directory.
+func cleanPath(p string) (string, bool) {
+	p = path.Clean(strings.Trim(p, "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return p, true
+}
+
+// relRoot returns the relative path from the directory of p to the root.
+func relRoot(p string) string {
+	return strings.Repeat("../", strings.Count(p, "/"))
+}
+
+var articleTmpl = template.Must(template.New("article").Parse(`{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<div><a href="{{.Index}}">索引</a> | <a href="{{.Raw}}">原始檔案</a></div>
+<div id="main-content" class="bbs-screen bbs-content">{{end}}
+{{define "foot"}}</div>
+</body>
+</html>
+{{end}}`))
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Brdname}} 精華區</title>
+</head>
+<body>
+<h1>{{.Brdname}} 精華區</h1>
+<p>{{.Result.Entries}} 項目，{{.Result.Bytes}} 位元組</p>
+{{if .Result.Truncated}}<p>{{.Result.Truncated}} 篇文章過長，僅匯出開頭部分</p>{{end}}
+{{if .ErrMsg}}<p>匯出未完成：{{.ErrMsg}}</p>{{end}}
+{{template "tree" .Root}}
+</body>
+</html>
+{{define "tree"}}<ul>
+{{range .Children}}<li>{{if .IsDir}}{{.Title}}{{template "tree" .}}{{else if .Exported}}<a href="{{.Path}}.html">{{.Title}}</a>{{if .Truncated}}（已截斷）{{end}}{{else}}{{.Title}}{{end}}</li>
+{{end}}</ul>{{end}}
+`))
@@ -0,0 +1,243 @@
+package manexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	dirs     map[string][]Entry
+	articles map[string]string
+	// maxLength, if not zero, cuts articles longer than it.
+	maxLength int
+}
+
+func (s *fakeSource) List(ctx context.Context, dir string) ([]Entry, error) {
+	ents, ok := s.dirs[dir]
+	if !ok {
+		return nil, errors.New("no such dir")
+	}
+	return ents, nil
+}
+
+func (s *fakeSource) Article(ctx context.Context, p string) ([]byte, bool, error) {
+	a, ok := s.articles[p]
+	if !ok {
+		return nil, false, errors.New("no such article")
+	}
+	if s.maxLength > 0 && len(a) > s.maxLength {
+		return []byte(a[:s.maxLength]), true, nil
+	}
+	return []byte(a), false, nil
+}
+
+func testSource() *fakeSource {
+	return &fakeSource{
+		dirs: map[string][]Entry{
+			"": {
+				{Path: "M.1.A", Title: "first"},
+				{Path: "D1", Title: "dir", IsDir: true},
+				{Path: "../etc", Title: "bad"},
+			},
+			"D1": {
+				{Path: "D1/M.2.A", Title: "second"},
+			},
+		},
+		articles: map[string]string{
+			"M.1.A":    "hello",
+			"D1/M.2.A": "\x1b[1;31mworld\x1b[m",
+		},
+	}
+}
+
+func testRender(ctx context.Context, w io.Writer, content []byte) error {
+	template.HTMLEscape(w, content)
+	return nil
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestWriteZip(t *testing.T) {
+	e := &Exporter{
+		Brdname: "Test",
+		Source:  testSource(),
+		Render:  testRender,
+	}
+	var buf bytes.Buffer
+	res, err := e.WriteZip(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Err != nil || res.Entries != 3 {
+		t.Errorf("result = %+v, want 3 entries without error", res)
+	}
+
+	files := readZip(t, buf.Bytes())
+	if got := files["Test/D1/M.2.A.ansi"]; got != "\x1b[1;31mworld\x1b[m" {
+		t.Errorf("raw content = %q", got)
+	}
+	if got := files["Test/D1/M.2.A.html"]; !strings.Contains(got, `href="../index.html"`) || !strings.Contains(got, "world") {
+		t.Errorf("article html missing index link or content: %q", got)
+	}
+	index := files["Test/index.html"]
+	for _, want := range []string{`href="M.1.A.html"`, `href="D1/M.2.A.html"`, "dir"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html missing %q", want)
+		}
+	}
+	for name := range files {
+		if strings.Contains(name, "..") {
+			t.Errorf("unexpected file %q", name)
+		}
+	}
+}
+
+type flushRecorder struct {
+	bytes.Buffer
+	// flushed is the length of the buffer at each flush.
+	flushed []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.Len())
+}
+
+func TestWriteZipFlush(t *testing.T) {
+	e := &Exporter{
+		Brdname: "Test",
+		Source:  testSource(),
+		Render:  testRender,
+	}
+	var rec flushRecorder
+	if _, err := e.WriteZip(context.Background(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.flushed) != 2 {
+		t.Fatalf("flushed %d times, want once per article", len(rec.flushed))
+	}
+	if rec.flushed[0] == 0 || rec.flushed[0] >= rec.flushed[1] {
+		t.Errorf("flushed at %v, want the first article sent before the second", rec.flushed)
+	}
+}
+
+func TestWriteZipLimits(t *testing.T) {
+	for _, test := range []struct {
+		limits  Limits
+		wantErr error
+	}{
+		{Limits{MaxEntries: 2}, ErrEntryLimit},
+		{Limits{MaxBytes: 8}, ErrSizeLimit},
+	} {
+		e := &Exporter{
+			Brdname: "Test",
+			Source:  testSource(),
+			Render:  testRender,
+			Limits:  test.limits,
+		}
+		var buf bytes.Buffer
+		res, err := e.WriteZip(context.Background(), &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(res.Err, test.wantErr) {
+			t.Errorf("limits %+v: result error = %v, want %v", test.limits, res.Err, test.wantErr)
+		}
+		files := readZip(t, buf.Bytes())
+		if _, ok := files["Test/M.1.A.html"]; !ok {
+			t.Errorf("limits %+v: first article missing", test.limits)
+		}
+		if _, ok := files["Test/D1/M.2.A.html"]; ok {
+			t.Errorf("limits %+v: article beyond limits exported", test.limits)
+		}
+		if !strings.Contains(files["Test/index.html"], test.wantErr.Error()) {
+			t.Errorf("limits %+v: index.html missing error", test.limits)
+		}
+	}
+}
+
+func TestWriteZipTruncated(t *testing.T) {
+	src := testSource()
+	src.maxLength = 5
+	e := &Exporter{
+		Brdname: "Test",
+		Source:  src,
+		Render:  testRender,
+	}
+	var buf bytes.Buffer
+	res, err := e.WriteZip(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Err != nil || res.Truncated != 1 {
+		t.Errorf("result = %+v, want 1 truncated article without error", res)
+	}
+	index := readZip(t, buf.Bytes())["Test/index.html"]
+	if !strings.Contains(index, `href="D1/M.2.A.html">second</a>（已截斷）`) {
+		t.Errorf("index.html missing truncated article: %q", index)
+	}
+	if strings.Contains(index, `href="M.1.A.html">first</a>（已截斷）`) {
+		t.Errorf("index.html marks untruncated article: %q", index)
+	}
+}
+
+func TestWriteZipRootError(t *testing.T) {
+	e := &Exporter{
+		Brdname: "Test",
+		Source:  &fakeSource{},
+		Render:  testRender,
+	}
+	var buf bytes.Buffer
+	if _, err := e.WriteZip(context.Background(), &buf); err == nil {
+		t.Error("expected error listing root")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %d bytes on error", buf.Len())
+	}
+}
+
+func TestSign(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	q := Sign("secret", "Test", now.Add(time.Hour))
+	if err := Verify("secret", "Test", q, now); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if err := Verify("secret", "test", q, now); err != nil {
+		t.Errorf("Verify() board name in other case = %v", err)
+	}
+	if err := Verify("secret", "Other", q, now); err != ErrSigMismatch {
+		t.Errorf("Verify() other board = %v, want %v", err, ErrSigMismatch)
+	}
+	if err := Verify("", "Test", q, now); err != ErrSigMismatch {
+		t.Errorf("Verify() no secret = %v, want %v", err, ErrSigMismatch)
+	}
+	if err := Verify("secret", "Test", q, now.Add(2*time.Hour)); err != ErrSigExpired {
+		t.Errorf("Verify() later = %v, want %v", err, ErrSigExpired)
+	}
+}
@@ -0,0 +1,52 @@
+package manexport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSigMismatch = errors.New("man export signature mismatch")
+	ErrSigExpired  = errors.New("man export signature expired")
+)
+
+// Sign returns query parameters authorizing to export the board until
+// expire. Board names are case insensitive.
+func Sign(secret, brdname string, expire time.Time) url.Values {
+	exp := strconv.FormatInt(expire.Unix(), 10)
+	q := make(url.Values)
+	q.Set("exp", exp)
+	q.Set("sig", signature(secret, brdname, exp))
+	return q
+}
+
+// Verify checks query parameters made by Sign.
+func Verify(secret, brdname string, q url.Values, now time.Time) error {
+	if secret == "" {
+		return ErrSigMismatch
+	}
+	exp := q.Get("exp")
+	expire, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrSigMismatch
+	}
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(signature(secret, brdname, exp))) {
+		return ErrSigMismatch
+	}
+	if now.Unix() > expire {
+		return ErrSigExpired
+	}
+	return nil
+}
+
+func signature(secret, brdname, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(brdname) + "/" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
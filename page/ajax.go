@@ -9,6 +9,17 @@ type ArticlePollResp struct {
 	ContentHtml string `json:"contentHtml"`
 	PollUrl     string `json:"pollUrl"`
 	Success     bool   `json:"success"`
+	HasMore     bool   `json:"hasMore,omitempty"`
+}
+
+// ArticleRangeResp is a chunk of the middle of a truncated article. NextUrl
+// loads the next chunk if any. Readers have to reload the page if Stale is
+// set, as the article has changed.
+type ArticleRangeResp struct {
+	ContentHtml string `json:"contentHtml"`
+	NextUrl     string `json:"nextUrl,omitempty"`
+	Success     bool   `json:"success"`
+	Stale       bool   `json:"stale,omitempty"`
 }
 
 func WriteAjaxResp(w http.ResponseWriter, obj interface{}) error {
@@ -1,15 +1,17 @@
 package page
 
 const (
-	TnameError      = `error.html`
-	TnameNotFound   = `notfound.html`
-	TnameClasslist  = `classlist.html`
-	TnameBbsIndex   = `bbsindex.html`
-	TnameBbsArticle = `bbsarticle.html`
-	TnameAskOver18  = `askover18.html`
-	TnameManIndex   = `manindex.html`
-	TnameManArticle = `manarticle.html`
-	TnameCaptcha    = `captcha.html`
+	TnameError       = `error.html`
+	TnameNotFound    = `notfound.html`
+	TnameClasslist   = `classlist.html`
+	TnameBbsIndex    = `bbsindex.html`
+	TnameBbsArticle  = `bbsarticle.html`
+	TnameAskOver18   = `askover18.html`
+	TnameManIndex    = `manindex.html`
+	TnameManArticle  = `manarticle.html`
+	TnameCaptcha     = `captcha.html`
+	TnameUserProfile = `userprofile.html`
+	TnameManSearch   = `mansearch.html`
 
 	TnameLayout = `layout.html`
 	TnameCommon = `common.html`
@@ -74,6 +74,10 @@ type BbsIndex struct {
 	Articles []pttbbs.Article
 	Bottoms  []pttbbs.Article
 
+	// SseUrl and WebSocketUrl stream new posts. Set for the last page only.
+	SseUrl       string
+	WebSocketUrl string
+
 	IsValid bool
 }
 
@@ -89,15 +93,20 @@ type BbsArticle struct {
 	ContentTruncated bool
 	PollUrl          string
 	LongPollUrl      string
+	SseUrl           string
+	WebSocketUrl     string
 	CurrOffset       int
+	// MiddleUrl loads the middle left out of truncated content, if set.
+	MiddleUrl string
 }
 
 func (BbsArticle) TemplateName() string { return TnameBbsArticle }
 
 type ManIndex struct {
-	Board   pttbbs.Board
-	Path    string
-	Entries []*manpb.Entry
+	Board       pttbbs.Board
+	Path        string
+	Entries     []*manpb.Entry
+	Breadcrumbs []ManBreadcrumb
 }
 
 func (ManIndex) TemplateName() string { return TnameManIndex }
@@ -110,10 +119,73 @@ type ManArticle struct {
 	Content          template.HTML
 	ContentTail      template.HTML
 	ContentTruncated bool
+	PollUrl          string
+	CurrOffset       int
+	Breadcrumbs      []ManBreadcrumb
 }
 
 func (ManArticle) TemplateName() string { return TnameManArticle }
 
+// ManBreadcrumb is an ancestor directory, or the page itself as the last
+// one, of a man page.
+type ManBreadcrumb struct {
+	Path  string
+	Title string
+	IsDir bool
+}
+
+type ManSearch struct {
+	Board   pttbbs.Board
+	Query   string
+	Results []ManSearchResult
+	// Truncated indicates not all of the man tree was searched.
+	Truncated bool
+}
+
+func (ManSearch) TemplateName() string { return TnameManSearch }
+
+type ManSearchResult struct {
+	Path  string
+	Title string
+	IsDir bool
+	// DirTitle is the title of the directory containing the entry.
+	DirTitle string
+}
+
+type UserProfile struct {
+	UserID string
+
+	// Boards lists boards having posts by the user, the most active first.
+	Boards []UserBoardPosts
+
+	// NumPosts is the number of posts by the user in Boards.
+	NumPosts int
+	// RecentRecommends is the sum of recommends of the recent posts listed
+	// in Boards, not of all posts.
+	RecentRecommends int
+
+	// IsPartial is set when some boards couldn't be searched and are left
+	// out.
+	IsPartial bool
+
+	IsValid bool
+}
+
+func (UserProfile) TemplateName() string { return TnameUserProfile }
+
+type UserBoardPosts struct {
+	Board pttbbs.Board
+
+	// NumPosts is the number of posts by the user in the board.
+	NumPosts int
+
+	// RecentRecommends is the sum of recommends of Articles.
+	RecentRecommends int
+
+	// Articles are the most recent posts by the user, newest first.
+	Articles []pttbbs.Article
+}
+
 type Captcha struct {
 	// Handle is the opaque handle for getting the verification key from
 	// database.
@@ -130,9 +202,33 @@ type Captcha struct {
 	// CaptchaErr signals types of errors.
 	CaptchaErr CaptchaErr
 
+	// ReturnTo, if non-empty along with VerificationKey, is where the user
+	// should continue to, instead of entering the key in BBS.
+	ReturnTo string
+
+	// Provider is the captcha provider, e.g. "recaptcha", "recaptcha_v3",
+	// "hcaptcha", "turnstile" or "local".
+	Provider string
+
+	// SiteKey, ScriptURL and Action are for providers with a widget.
+	// Action is the reCAPTCHA v3 action name.
+	SiteKey   string
+	ScriptURL string
+	Action    string
+
+	// ResponseField is the form field the response is posted in.
+	ResponseField string
+
 	// RecaptchaSiteKey is the recaptcha site key.
 	RecaptchaSiteKey string
 
+	// LocalChallenge is posted back along with the answer of the local
+	// captcha. The question is either in LocalQuestion as text or in
+	// LocalImage as a data URL.
+	LocalChallenge string
+	LocalQuestion  string
+	LocalImage     template.URL
+
 	// PostAction is the url to post response to.
 	PostAction string
 }
@@ -140,6 +236,8 @@ type Captcha struct {
 func (Captcha) TemplateName() string { return TnameCaptcha }
 
 type CaptchaErr struct {
-	IsVerifyFailed bool
-	IsNotFound     bool
+	IsVerifyFailed    bool
+	IsNotFound        bool
+	IsUsed            bool
+	IsTooManyAttempts bool
 }
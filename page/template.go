@@ -20,6 +20,8 @@ var (
 		{TnameManIndex, TnameLayout, TnameCommon},
 		{TnameManArticle, TnameLayout, TnameCommon},
 		{TnameCaptcha, TnameLayout, TnameCommon},
+		{TnameUserProfile, TnameLayout, TnameCommon},
+		{TnameManSearch, TnameLayout, TnameCommon},
 	}
 
 	tmpl TemplateMap
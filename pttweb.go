@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
@@ -19,7 +18,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -27,13 +28,17 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/ptt/pttweb/atomfeed"
+	"github.com/ptt/pttweb/breaker"
 	"github.com/ptt/pttweb/cache"
 	"github.com/ptt/pttweb/captcha"
 	"github.com/ptt/pttweb/extcache"
+	"github.com/ptt/pttweb/gate"
 	"github.com/ptt/pttweb/page"
 	manpb "github.com/ptt/pttweb/proto/man"
 	"github.com/ptt/pttweb/pttbbs"
 	"github.com/ptt/pttweb/pushstream"
+	"github.com/ptt/pttweb/ratelimit"
+	"github.com/ptt/pttweb/richcontent"
 
 	"github.com/gorilla/mux"
 )
@@ -44,11 +49,27 @@ const (
 	BbsIndexLastPageCacheTimeout  = time.Minute * 1
 	BbsSearchCacheTimeout         = time.Minute * 10
 	BbsSearchLastPageCacheTimeout = time.Minute * 3
+	UserProfileCacheTimeout       = time.Minute * 10
+	GroupFeedCacheTimeout         = time.Minute * 10
+	ManIndexCacheTimeout          = time.Minute * 5
+	ManSearchCacheTimeout         = time.Minute * 10
+
+	// PartialResultCacheTimeout is for results missing some boards due to
+	// errors, e.g. busy backends.
+	PartialResultCacheTimeout = time.Minute
+
+	// BoardWatchInterval is the interval to check for new posts of boards
+	// with live index readers.
+	BoardWatchInterval = time.Second * 10
+
+	// BoardFragmentMaxPosts is the most posts sent to a live index reader
+	// at once. Readers further behind skip to the latest posts.
+	BoardFragmentMaxPosts = EntryPerPage * 5
 )
 
 var (
 	ErrOver18CookieNotEnabled = errors.New("board is over18 but cookie not enabled")
-	ErrSigMismatch            = errors.New("push stream signature mismatch")
+	ErrBadSignedArg           = errors.New("missing or malformed signed argument")
 )
 
 var ptt pttbbs.Pttbbs
@@ -58,6 +79,9 @@ var router *mux.Router
 var cacheMgr *cache.CacheManager
 var extCache extcache.ExtCache
 var atomConverter *atomfeed.Converter
+var pushKeys *pushstream.Keys
+var pushServer *pushstream.Server
+var rateLimiter *ratelimit.Limiter
 
 var configPath string
 var config PttwebConfig
@@ -89,24 +113,39 @@ func main() {
 		log.Fatal("loadConfig:", err)
 	}
 
+	if err := setupEmbedProviders(); err != nil {
+		log.Fatal("setupEmbedProviders:", err)
+	}
+
 	// Init RemotePtt
-	var err error
-	ptt, err = pttbbs.NewGrpcRemotePtt(config.BoarddAddress)
+	boarddGate = gate.New(config.BoarddMaxConn, config.BoarddMaxWait)
+	searchGate = gate.New(config.SearchMaxConn, config.SearchMaxWait)
+	mandGate = gate.New(config.MandMaxConn, config.MandMaxWait)
+	mandBreaker = breaker.New(config.MandBreakerThreshold, time.Duration(config.MandBreakerCooldownSecs)*time.Second)
+
+	remotePtt, err := pttbbs.NewGrpcRemotePtt(config.BoarddAddress)
 	if err != nil {
 		log.Fatal("cannot connect to boardd:", config.BoarddAddress, err)
 	}
+	ptt = newGatedPttbbs(remotePtt, boarddGate, "boardd")
 
 	if config.SearchAddress != "" {
-		pttSearch, err = pttbbs.NewGrpcRemotePtt(config.SearchAddress)
+		remoteSearch, err := pttbbs.NewGrpcRemotePtt(config.SearchAddress)
 		if err != nil {
 			log.Fatal("cannot connect to boardd:", config.SearchAddress, err)
 		}
+		pttSearch = newGatedPttbbs(remoteSearch, searchGate, "search")
 	} else {
-		pttSearch = ptt
+		pttSearch = newGatedPttbbs(remotePtt, searchGate, "search")
 	}
 
 	// Init mand connection
-	if conn, err := grpc.Dial(config.MandAddress, grpc.WithInsecure(), grpc.WithBackoffMaxDelay(time.Second*5)); err != nil {
+	if conn, err := grpc.Dial(config.MandAddress, grpc.WithInsecure(), grpc.WithBackoffMaxDelay(time.Second*5),
+		grpc.WithChainUnaryInterceptor(
+			gateUnaryInterceptor(mandGate, "mand"),
+			breakerUnaryInterceptor(mandBreaker, time.Duration(config.MandTimeoutSecs)*time.Second,
+				func(err error) error { return NewManUnavailableError(err) }),
+		)); err != nil {
 		log.Fatal("cannot connect to mand:", config.MandAddress, err)
 	} else {
 		mand = manpb.NewManServiceClient(conn)
@@ -124,6 +163,13 @@ func main() {
 		LinkFeed: func(brdname string) (string, error) {
 			return config.FeedPrefix + "/" + brdname + ".xml", nil
 		},
+		LinkBoard: func(brdname string) (string, error) {
+			u, err := router.Get("bbsindex").URLPath("brdname", brdname)
+			if err != nil {
+				return "", err
+			}
+			return config.SitePrefix + u.String(), nil
+		},
 		LinkArticle: func(brdname, filename string) (string, error) {
 			u, err := router.Get("bbsarticle").URLPath("brdname", brdname, "filename", filename)
 			if err != nil {
@@ -133,10 +179,31 @@ func main() {
 		},
 	}
 
+	// Init builtin push stream server.
+	pushKeys = config.pushStreamKeys()
+	if config.EnablePushStream && config.EnableBuiltinPushStream {
+		pushServer = pushstream.NewServer(config.PushStreamSharedSecret, config.PushStreamPublishSecret, pushKeys, renderArticleFragment, renderBoardFragment)
+		go pushServer.WatchBoards(BoardWatchInterval, func(brdname string) (int, error) {
+			brd, err := pttbbs.OneBoard(ptt.GetBoards(pttbbs.BoardRefByName(brdname)))
+			return brd.NumPosts, err
+		})
+	}
+
+	// Init rate limiter
+	if config.RateLimitConfig.Enabled {
+		rateLimiter, err = ratelimit.New(config.RateLimitConfig)
+		if err != nil {
+			log.Fatal("ratelimit.New:", err)
+		}
+	}
+
 	// Load templates
 	if err := page.LoadTemplates(config.TemplateDirectory, templateFuncMap()); err != nil {
 		log.Fatal("cannot load templates:", err)
 	}
+	if err := setupETagSeed(); err != nil {
+		log.Fatal("setupETagSeed:", err)
+	}
 
 	// Init router
 	router = createRouter()
@@ -169,6 +236,21 @@ func main() {
 	<-progExit
 }
 
+func setupEmbedProviders() error {
+	var oembedProviders []richcontent.ProviderConfig
+	if config.OEmbedProvidersFile != "" {
+		f, err := os.Open(config.OEmbedProvidersFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if oembedProviders, err = richcontent.LoadOEmbedProviders(f); err != nil {
+			return err
+		}
+	}
+	return richcontent.SetProviders(config.EmbedProviders, oembedProviders)
+}
+
 func ReplaceVars(p string) string {
 	var subs = [][]string{
 		{`aidc`, `[0-9A-Za-z\-_]+`},
@@ -176,6 +258,7 @@ func ReplaceVars(p string) string {
 		{`filename`, `[MG]\.\d+\.A(?:\.[0-9A-F]+)?`},
 		{`fullpath`, `[0-9A-Za-z_\.\-\/]+`},
 		{`page`, `\d+`},
+		{`userid`, `[a-zA-Z][0-9a-zA-Z]{1,11}`},
 	}
 	for _, s := range subs {
 		p = strings.Replace(p, fmt.Sprintf(`{%v}`, s[0]), fmt.Sprintf(`{%v:%v}`, s[0], s[1]), -1)
@@ -195,7 +278,8 @@ func createRouter() *mux.Router {
 		Handler(ErrorWrapper(handleCls)).
 		Name("classlist")
 	r.Path(ReplaceVars(`/bbs/{x:|index\.html|hotboards\.html}`)).
-		Handler(ErrorWrapper(handleHotboards))
+		Handler(ErrorWrapper(handleHotboards)).
+		Name("hotboards")
 
 	// Board
 	r.Path(ReplaceVars(`/bbs/{brdname}{x:/?}`)).
@@ -210,10 +294,41 @@ func createRouter() *mux.Router {
 		Handler(ErrorWrapper(handleBbsSearch)).
 		Name("bbssearch")
 
+	// User
+	r.Path(ReplaceVars(`/user/{userid}`)).
+		Handler(ErrorWrapper(handleUserProfile)).
+		Name("userprofile")
+
 	// Feed
 	r.Path(ReplaceVars(`/atom/{brdname}.xml`)).
-		Handler(ErrorWrapper(handleBoardAtomFeed)).
+		Handler(boardFeedHandler(atomfeed.AtomEncoder{}, func(brdname string) (string, error) {
+			return atomConverter.LinkFeed(brdname)
+		})).
 		Name("atomfeed")
+	r.Path(ReplaceVars(`/rss/{brdname}.xml`)).
+		Handler(boardFeedHandler(atomfeed.RSSEncoder{}, linkBoardFeed("rssfeed"))).
+		Name("rssfeed")
+	r.Path(ReplaceVars(`/feed/{brdname}.json`)).
+		Handler(boardFeedHandler(atomfeed.JSONFeedEncoder{}, linkBoardFeed("jsonfeed"))).
+		Name("jsonfeed")
+	r.Path(`/atom/cls/hotboards.xml`).
+		Handler(groupFeedHandler(atomfeed.AtomEncoder{}, true)).
+		Name("atomfeed_hotboards")
+	r.Path(`/rss/cls/hotboards.xml`).
+		Handler(groupFeedHandler(atomfeed.RSSEncoder{}, true)).
+		Name("rssfeed_hotboards")
+	r.Path(`/feed/cls/hotboards.json`).
+		Handler(groupFeedHandler(atomfeed.JSONFeedEncoder{}, true)).
+		Name("jsonfeed_hotboards")
+	r.Path(`/atom/cls/{bid:[0-9]+}.xml`).
+		Handler(groupFeedHandler(atomfeed.AtomEncoder{}, false)).
+		Name("atomfeed_cls")
+	r.Path(`/rss/cls/{bid:[0-9]+}.xml`).
+		Handler(groupFeedHandler(atomfeed.RSSEncoder{}, false)).
+		Name("rssfeed_cls")
+	r.Path(`/feed/cls/{bid:[0-9]+}.json`).
+		Handler(groupFeedHandler(atomfeed.JSONFeedEncoder{}, false)).
+		Name("jsonfeed_cls")
 
 	// Post
 	r.Path(ReplaceVars(`/bbs/{brdname}/{filename}.html`)).
@@ -223,31 +338,122 @@ func createRouter() *mux.Router {
 		Handler(ErrorWrapper(handleAidc)).
 		Name("bbsaidc")
 
+	// Ranges are signed, and could be forged without a key.
+	if pushKeys.CanSign() {
+		r.Path(ReplaceVars(`/range/{brdname}/{filename}.html`)).
+			Handler(ErrorWrapper(handleArticleRange)).
+			Name("bbsarticlerange")
+	}
+
 	if config.EnablePushStream {
 		r.Path(ReplaceVars(`/poll/{brdname}/{filename}.html`)).
 			Handler(ErrorWrapper(handleArticlePoll)).
 			Name("bbsarticlepoll")
 	}
 
+	if pushServer != nil {
+		r.Path(ReplaceVars(`/stream/{brdname}/{filename}.sse`)).
+			Handler(ErrorWrapper(handleArticleSSE)).
+			Name("bbsarticlesse")
+		r.Path(ReplaceVars(`/stream/{brdname}/{filename}.ws`)).
+			Handler(ErrorWrapper(handleArticleWebSocket)).
+			Name("bbsarticlews")
+		r.Path(ReplaceVars(`/stream/{brdname}/index.sse`)).
+			Handler(ErrorWrapper(handleBoardSSE)).
+			Name("bbsindexsse")
+		r.Path(ReplaceVars(`/stream/{brdname}/index.ws`)).
+			Handler(ErrorWrapper(handleBoardWebSocket)).
+			Name("bbsindexws")
+		if config.PushStreamPublishSecret != "" {
+			r.Path(`/stream/publish`).
+				HandlerFunc(pushServer.HandlePublish).
+				Name("pushpublish")
+		}
+	}
+
 	r.Path(ReplaceVars(`/ask/over18`)).
 		Handler(ErrorWrapper(handleAskOver18)).
 		Name("askover18")
 
 	// Man
+	r.Path(ReplaceVars(`/man/{brdname}/search.html`)).
+		Handler(ErrorWrapper(handleManSearch)).
+		Name("mansearch")
+	if len(config.ManExportBoards) > 0 || config.ManExportSecret != "" {
+		r.Path(ReplaceVars(`/man/{brdname}/export.zip`)).
+			Handler(ErrorWrapper(handleManExport)).
+			Name("manexport")
+	}
+	// Offsets of polling are signed, and could be forged without a key.
+	if pushKeys.CanSign() {
+		r.Path(ReplaceVars(`/poll/man/{fullpath}.html`)).
+			Handler(ErrorWrapper(handleManArticlePoll)).
+			Name("manarticlepoll")
+	}
 	r.Path(ReplaceVars(`/man/{fullpath}.html`)).
 		Handler(ErrorWrapper(handleMan)).
 		Name("manentry")
 
+	if config.EnableGateStats {
+		r.Path(`/debug/gates`).
+			HandlerFunc(handleGateStats).
+			Name("gatestats")
+	}
+
 	// Captcha
 	if cfg := config.captchaConfig(); cfg.Enabled {
-		if err := captcha.Install(cfg, r); err != nil {
+		proxies, err := ratelimit.ParseProxies(config.RateLimitConfig.TrustedProxies)
+		if err != nil {
+			log.Fatal("ratelimit.ParseProxies:", err)
+		}
+		h, err := captcha.Install(cfg, r, proxies.ClientIP)
+		if err != nil {
 			log.Fatal("captcha.Install:", err)
 		}
+		if rateLimiter != nil && config.RateLimitCaptchaRedirect {
+			h.OnSolved = func(r *http.Request) {
+				if err := rateLimiter.Exempt(r); err != nil {
+					log.Println("ratelimit: exempt:", err)
+				}
+			}
+			rateLimiter.OnLimited = rateLimitedHandler(h)
+		}
+	}
+
+	if rateLimiter != nil {
+		r.Use(rateLimiter.Middleware)
 	}
 
 	return r
 }
 
+// rateLimitedHandler redirects limited page views to captcha, which exempts
+// the visitor once solved. Other requests get 429.
+func rateLimitedHandler(h *captcha.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		handle, err := h.IssueHandle(r, r.URL.RequestURI())
+		if err != nil {
+			log.Println("captcha: issue handle:", err)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		u, err := router.Get("captcha").URLPath()
+		if err != nil {
+			log.Println("captcha: route:", err)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		q := make(url.Values)
+		q.Set(captcha.CaptchaHandle, handle)
+		http.Redirect(w, r, u.String()+"?"+q.Encode(), http.StatusFound)
+	}
+}
+
 func templateFuncMap() template.FuncMap {
 	return template.FuncMap{
 		"route_bbsindex": func(b pttbbs.Board) (*url.URL, error) {
@@ -302,6 +508,12 @@ func templateFuncMap() template.FuncMap {
 			}
 			return bbsSearchURL(b, "author:"+author)
 		},
+		"route_userprofile": func(userID string) (*url.URL, error) {
+			if !pttbbs.IsValidUserID(userID) {
+				return nil, nil
+			}
+			return router.Get("userprofile").URLPath("userid", userID)
+		},
 		"route_search_thread": func(b pttbbs.Board, title string) (*url.URL, error) {
 			return bbsSearchURL(b, "thread:"+pttbbs.Subject(title))
 		},
@@ -407,11 +619,12 @@ func handleClsWithBid(c *Context, w http.ResponseWriter, bid pttbbs.BoardID) err
 		return NewNotFoundError(fmt.Errorf("invalid bid: %v", bid))
 	}
 
-	board, err := pttbbs.OneBoard(ptt.GetBoards(pttbbs.BoardRefByBid(bid)))
+	p := withContext(ptt, c.R.Context())
+	board, err := pttbbs.OneBoard(p.GetBoards(pttbbs.BoardRefByBid(bid)))
 	if err != nil {
 		return err
 	}
-	children, err := ptt.GetBoards(pttbbs.BoardRefsByBid(board.Children)...)
+	children, err := p.GetBoards(pttbbs.BoardRefsByBid(board.Children)...)
 	if err != nil {
 		return err
 	}
@@ -421,7 +634,7 @@ func handleClsWithBid(c *Context, w http.ResponseWriter, bid pttbbs.BoardID) err
 }
 
 func handleHotboards(c *Context, w http.ResponseWriter) error {
-	boards, err := ptt.Hotboards()
+	boards, err := withContext(ptt, c.R.Context()).Hotboards()
 	if err != nil {
 		return err
 	}
@@ -484,7 +697,22 @@ func handleBbs(c *Context, w http.ResponseWriter) error {
 		return NewNotFoundError(fmt.Errorf("not a valid cache.BbsIndex: %v/%v", brd.BrdName, pageNo))
 	}
 
-	return page.ExecutePage(w, (*page.BbsIndex)(bbsindex))
+	modified := lastArticleModified(bbsindex.Articles, bbsindex.Bottoms)
+	etag := makeETag("bbsindex", brd.BrdName, strconv.Itoa(pageNo), strconv.Itoa(brd.NumPosts), strconv.FormatInt(modified.UnixNano(), 10),
+		// Signed offsets in the page expire.
+		strconv.FormatInt(pushKeys.ExpireAt(time.Now()), 10))
+	if checkNotModified(c, w, etag, modified, timeout, brd.Over18) {
+		return nil
+	}
+
+	p := page.BbsIndex(*bbsindex)
+	if pageNo == 0 {
+		p.SseUrl, p.WebSocketUrl, err = uriForBoardStreaming(brd.BrdName, bbsindex.Board.NumPosts)
+		if err != nil {
+			return err
+		}
+	}
+	return page.ExecutePage(w, &p)
 }
 
 func bbsSearchURL(b pttbbs.Board, query string) (*url.URL, error) {
@@ -601,35 +829,131 @@ func handleBbsSearch(c *Context, w http.ResponseWriter) error {
 	return page.ExecutePage(w, (*page.BbsIndex)(bbsindex))
 }
 
-func handleBoardAtomFeed(c *Context, w http.ResponseWriter) error {
+func handleUserProfile(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	userID := vars["userid"]
+	if !pttbbs.IsValidUserID(userID) {
+		return NewNotFoundError(fmt.Errorf("invalid user id: %v", userID))
+	}
+
+	obj, err := cacheMgr.Get(&UserProfileRequest{
+		UserID: userID,
+	}, ZeroUserProfile, UserProfileCacheTimeout, generateUserProfile)
+	if err != nil {
+		return err
+	}
+	up := obj.(*UserProfile)
+
+	if !up.IsValid {
+		return NewNotFoundError(fmt.Errorf("not a valid cache.UserProfile: %v", userID))
+	}
+
+	return page.ExecutePage(w, (*page.UserProfile)(up))
+}
+
+func boardFeedHandler(enc atomfeed.Encoder, linkSelf func(brdname string) (string, error)) ErrorWrapper {
+	return func(c *Context, w http.ResponseWriter) error {
+		return handleBoardFeed(c, w, enc, linkSelf)
+	}
+}
+
+func linkBoardFeed(routeName string) func(brdname string) (string, error) {
+	return func(brdname string) (string, error) {
+		u, err := router.Get(routeName).URLPath("brdname", brdname)
+		if err != nil {
+			return "", err
+		}
+		return config.SitePrefix + u.String(), nil
+	}
+}
+
+func handleBoardFeed(c *Context, w http.ResponseWriter, enc atomfeed.Encoder, linkSelf func(brdname string) (string, error)) error {
 	vars := mux.Vars(c.R)
 	brdname := vars["brdname"]
+	query := strings.TrimSpace(c.R.FormValue("q"))
 
 	timeout := BbsIndexLastPageCacheTimeout
 
+	preds, err := parseQuery(query)
+	if err != nil {
+		return err
+	}
+
 	c.SetSkipOver18()
 	brd, err := getBoardByName(c, brdname)
 	if err != nil {
 		return err
 	}
 
-	obj, err := cacheMgr.Get(&BoardAtomFeedRequest{
-		Brd: *brd,
-	}, ZeroBoardAtomFeed, timeout, generateBoardAtomFeed)
+	obj, err := cacheMgr.Get(&BoardFeedRequest{
+		Brd:   *brd,
+		Query: query,
+		Preds: preds,
+	}, ZeroBoardFeed, timeout, generateBoardFeed)
+	if err != nil {
+		return err
+	}
+	bf := obj.(*BoardFeed)
+
+	if !bf.IsValid {
+		return NewNotFoundError(fmt.Errorf("not a valid cache.BoardFeed: %v", brd.BrdName))
+	}
+
+	selfURL, err := linkSelf(brd.BrdName)
 	if err != nil {
 		return err
 	}
-	baf := obj.(*BoardAtomFeed)
+	if query != "" {
+		selfURL += "?" + feedQueryValues(query).Encode()
+	}
+
+	w.Header().Set("Content-Type", enc.ContentType())
+	if checkFeedNotModified(c, w, bf.Feed, selfURL, timeout) {
+		return nil
+	}
+	return enc.Encode(w, bf.Feed, selfURL)
+}
+
+func groupFeedHandler(enc atomfeed.Encoder, hotboards bool) ErrorWrapper {
+	return func(c *Context, w http.ResponseWriter) error {
+		return handleGroupFeed(c, w, enc, hotboards)
+	}
+}
 
-	if !baf.IsValid {
-		return NewNotFoundError(fmt.Errorf("not a valid cache.BoardAtomFeed: %v", brd.BrdName))
+func handleGroupFeed(c *Context, w http.ResponseWriter, enc atomfeed.Encoder, hotboards bool) error {
+	req := &GroupFeedRequest{
+		Hotboards: hotboards,
+	}
+	if !hotboards {
+		bid, err := strconv.Atoi(mux.Vars(c.R)["bid"])
+		if err != nil || bid < 1 {
+			return NewNotFoundError(fmt.Errorf("invalid bid: %v", mux.Vars(c.R)["bid"]))
+		}
+		req.Bid = pttbbs.BoardID(bid)
 	}
 
-	w.Header().Set("Content-Type", "application/xml")
-	if _, err = w.Write([]byte(xml.Header)); err != nil {
+	obj, err := cacheMgr.Get(req, ZeroBoardFeed, GroupFeedCacheTimeout, generateGroupFeed)
+	if err != nil {
 		return err
 	}
-	return xml.NewEncoder(w).Encode(baf.Feed)
+	bf := obj.(*BoardFeed)
+
+	if !bf.IsValid {
+		return NewNotFoundError(fmt.Errorf("not a valid cache.BoardFeed: %v", req))
+	}
+
+	selfURL := config.SitePrefix + c.R.URL.Path
+	w.Header().Set("Content-Type", enc.ContentType())
+	if checkFeedNotModified(c, w, bf.Feed, selfURL, GroupFeedCacheTimeout) {
+		return nil
+	}
+	return enc.Encode(w, bf.Feed, selfURL)
+}
+
+func checkFeedNotModified(c *Context, w http.ResponseWriter, feed *atomfeed.Feed, selfURL string, maxAge time.Duration) bool {
+	modified := feedModified(feed)
+	etag := makeETag("feed", selfURL, strconv.Itoa(len(feed.Entries)), strconv.FormatInt(modified.UnixNano(), 10))
+	return checkNotModified(c, w, etag, modified, maxAge, false)
 }
 
 func handleArticle(c *Context, w http.ResponseWriter) error {
@@ -657,15 +981,14 @@ func handleArticleCommon(c *Context, w http.ResponseWriter, brdname, filename st
 		return err
 	}
 
+	// Readers go before crawlers.
+	prio := gate.PriorityHigh
+	if isCrawlerUserAgent(c.R) {
+		prio = gate.PriorityLow
+	}
+
 	// Render content
-	obj, err := cacheMgr.Get(&ArticleRequest{
-		Namespace: "bbs",
-		Brd:       *brd,
-		Filename:  filename,
-		Select: func(m pttbbs.SelectMethod, offset, maxlen int) (*pttbbs.ArticlePart, error) {
-			return ptt.GetArticleSelect(brd.Ref(), m, filename, "", offset, maxlen)
-		},
-	}, ZeroArticle, ArticleCacheTimeout, generateArticle)
+	obj, err := cacheMgr.Get(bbsArticleRequest(brd, filename, prio), ZeroArticle, ArticleCacheTimeout, generateArticle)
 	// Try older filename when not found.
 	if err == pttbbs.ErrNotFound {
 		if name, ok := oldFilename(filename); ok {
@@ -687,10 +1010,27 @@ func handleArticleCommon(c *Context, w http.ResponseWriter, brdname, filename st
 		log.Println("Large rendered article:", brd.BrdName, filename, len(ar.ContentHtml))
 	}
 
+	etag := makeETag("bbs", brd.BrdName, filename, ar.CacheKey, strconv.Itoa(ar.NextOffset),
+		strconv.FormatInt(pushKeys.ExpireAt(time.Now()), 10))
+	if checkNotModified(c, w, etag, time.Time{}, ArticleCacheTimeout, brd.Over18) {
+		return nil
+	}
+
 	pollUrl, longPollUrl, err := uriForPolling(brd.BrdName, filename, ar.CacheKey, ar.NextOffset)
 	if err != nil {
 		return err
 	}
+	sseUrl, wsUrl, err := uriForStreaming(brd.BrdName, filename, ar.CacheKey, ar.NextOffset)
+	if err != nil {
+		return err
+	}
+	var middleUrl string
+	if ar.IsTruncated && ar.MiddleOffset < ar.MiddleEnd {
+		middleUrl, err = uriForArticleRange(brd.BrdName, filename, ar.CacheKey, ar.MiddleOffset, ar.MiddleEnd)
+		if err != nil {
+			return err
+		}
+	}
 
 	return page.ExecutePage(w, &page.BbsArticle{
 		Title:            ar.ParsedTitle,
@@ -702,10 +1042,38 @@ func handleArticleCommon(c *Context, w http.ResponseWriter, brdname, filename st
 		ContentTruncated: ar.IsTruncated,
 		PollUrl:          pollUrl,
 		LongPollUrl:      longPollUrl,
+		SseUrl:           sseUrl,
+		WebSocketUrl:     wsUrl,
 		CurrOffset:       ar.NextOffset,
+		MiddleUrl:        middleUrl,
 	})
 }
 
+func bbsArticleRequest(brd *pttbbs.Board, filename string, prio gate.Priority) *ArticleRequest {
+	p := withPriority(ptt, prio)
+	return &ArticleRequest{
+		Namespace: "bbs",
+		Brd:       *brd,
+		Filename:  filename,
+		Select: func(m pttbbs.SelectMethod, offset, maxlen int) (*pttbbs.ArticlePart, error) {
+			return p.GetArticleSelect(brd.Ref(), m, filename, "", offset, maxlen)
+		},
+	}
+}
+
+func bbsArticlePartRequest(brd *pttbbs.Board, filename, cacheKey string, offset int) *ArticlePartRequest {
+	return &ArticlePartRequest{
+		Namespace: "bbs",
+		Brd:       *brd,
+		Filename:  filename,
+		CacheKey:  cacheKey,
+		Offset:    offset,
+		Select: func(cacheKey string, offset int) (*pttbbs.ArticlePart, error) {
+			return ptt.GetArticleSelect(brd.Ref(), pttbbs.SelectHead, filename, cacheKey, offset, -1)
+		},
+	}
+}
+
 // oldFilename returns the old filename of an article if any.  Older articles
 // have no random suffix. This will result into ".000" suffix when converted
 // from AID.
@@ -716,13 +1084,23 @@ func oldFilename(filename string) (string, bool) {
 	return filename[:len(filename)-4], true
 }
 
-func verifySignature(brdname, filename string, size int64, sig string) bool {
-	return (&pushstream.PushNotification{
-		Brdname:   brdname,
-		Filename:  filename,
-		Size:      size,
-		Signature: sig,
-	}).CheckSignature(config.PushStreamSharedSecret)
+// verifySignedArg reads and verifies the size signed by signedOffsetArgs or
+// publishers.
+func verifySignedArg(c *Context, brdname, filename, name string) (int, error) {
+	pn := &pushstream.PushNotification{
+		Brdname:  brdname,
+		Filename: filename,
+	}
+	if err := c.R.ParseForm(); err != nil {
+		return 0, ErrBadSignedArg
+	}
+	if err := pn.ParseArgs(c.R.Form, name); err != nil {
+		return 0, ErrBadSignedArg
+	}
+	if err := pushKeys.Verify(pn, time.Now()); err != nil {
+		return 0, err
+	}
+	return int(pn.Size), nil
 }
 
 func handleArticlePoll(c *Context, w http.ResponseWriter) error {
@@ -730,18 +1108,82 @@ func handleArticlePoll(c *Context, w http.ResponseWriter) error {
 	brdname := vars["brdname"]
 	filename := vars["filename"]
 	cacheKey := c.R.FormValue("cacheKey")
-	offset, err := strconv.Atoi(c.R.FormValue("offset"))
+
+	offset, err := verifySignedArg(c, brdname, filename, "offset")
+	if err == nil {
+		_, err = verifySignedArg(c, brdname, filename, "size")
+	}
+	switch err {
+	case nil:
+	case ErrBadSignedArg:
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	case pushstream.ErrSigMismatch, pushstream.ErrSigExpired:
+		// Readers with expired offsets have to reload the page.
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	default:
+		return err
+	}
+
+	brd, err := getBoardByName(c, brdname)
 	if err != nil {
 		return err
 	}
-	size, err := strconv.Atoi(c.R.FormValue("size"))
+
+	obj, err := cacheMgr.Get(bbsArticlePartRequest(brd, filename, cacheKey, offset), ZeroArticlePart, time.Minute, generateArticlePart)
 	if err != nil {
 		return err
 	}
+	ap := obj.(*ArticlePart)
+
+	res := new(page.ArticlePollResp)
+	res.Success = ap.IsValid
+	if ap.IsValid {
+		res.ContentHtml = ap.ContentHtml
+		res.PollUrl, _, err = uriForPolling(brdname, filename, ap.CacheKey, ap.NextOffset)
+		if err != nil {
+			return err
+		}
+	}
+	return page.WriteAjaxResp(w, res)
+}
+
+// rangeSignedName is the filename signing offsets of ranges of an article,
+// apart from polling offsets. It binds the end and cache key of the range to
+// the offset, so they are signed as a unit.
+func rangeSignedName(filename, cacheKey string, end int) string {
+	return fmt.Sprintf("range/%v/%v/%v", end, cacheKey, filename)
+}
 
-	if !verifySignature(brdname, filename, int64(offset), c.R.FormValue("offset-sig")) ||
-		!verifySignature(brdname, filename, int64(size), c.R.FormValue("size-sig")) {
-		return ErrSigMismatch
+// handleArticleRange returns a chunk of the middle left out of a truncated
+// article, between the signed offset and end.
+func handleArticleRange(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	brdname := vars["brdname"]
+	filename := vars["filename"]
+	cacheKey := c.R.FormValue("cacheKey")
+
+	end, err := strconv.Atoi(c.R.FormValue("end"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+	offset, err := verifySignedArg(c, brdname, rangeSignedName(filename, cacheKey, end), "offset")
+	switch err {
+	case nil:
+	case ErrBadSignedArg:
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	case pushstream.ErrSigMismatch, pushstream.ErrSigExpired:
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	default:
+		return err
+	}
+	if offset >= end {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
 	}
 
 	brd, err := getBoardByName(c, brdname)
@@ -749,29 +1191,46 @@ func handleArticlePoll(c *Context, w http.ResponseWriter) error {
 		return err
 	}
 
-	obj, err := cacheMgr.Get(&ArticlePartRequest{
+	obj, err := cacheMgr.Get(&ArticleRangeRequest{
 		Brd:      *brd,
 		Filename: filename,
 		CacheKey: cacheKey,
 		Offset:   offset,
-	}, ZeroArticlePart, time.Minute, generateArticlePart)
+		End:      end,
+	}, ZeroArticlePart, ArticleCacheTimeout, generateArticleRange)
 	if err != nil {
 		return err
 	}
 	ap := obj.(*ArticlePart)
 
-	res := new(page.ArticlePollResp)
+	res := new(page.ArticleRangeResp)
 	res.Success = ap.IsValid
+	res.Stale = ap.IsStale
 	if ap.IsValid {
 		res.ContentHtml = ap.ContentHtml
-		res.PollUrl, _, err = uriForPolling(brdname, filename, ap.CacheKey, ap.NextOffset)
-		if err != nil {
-			return err
+		if ap.HasMore {
+			res.NextUrl, err = uriForArticleRange(brdname, filename, ap.CacheKey, ap.NextOffset, end)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return page.WriteAjaxResp(w, res)
 }
 
+func uriForArticleRange(brdname, filename, cacheKey string, offset, end int) (string, error) {
+	if !pushKeys.CanSign() {
+		return "", nil
+	}
+	u, err := router.Get("bbsarticlerange").URLPath("brdname", brdname, "filename", filename)
+	if err != nil {
+		return "", err
+	}
+	args := signedOffsetArgs(brdname, rangeSignedName(filename, cacheKey, end), cacheKey, offset)
+	args.Set("end", strconv.Itoa(end))
+	return u.String() + "?" + args.Encode(), nil
+}
+
 func uriForPolling(brdname, filename, cacheKey string, offset int) (poll, longPoll string, err error) {
 	if !config.EnablePushStream {
 		return
@@ -782,17 +1241,12 @@ func uriForPolling(brdname, filename, cacheKey string, offset int) (poll, longPo
 		Filename: filename,
 		Size:     int64(offset),
 	}
-	pn.Sign(config.PushStreamSharedSecret)
 
 	next, err := router.Get("bbsarticlepoll").URLPath("brdname", brdname, "filename", filename)
 	if err != nil {
 		return
 	}
-	args := make(url.Values)
-	args.Set("cacheKey", cacheKey)
-	args.Set("offset", strconv.FormatInt(pn.Size, 10))
-	args.Set("offset-sig", pn.Signature)
-	poll = next.String() + "?" + args.Encode()
+	poll = next.String() + "?" + signedOffsetArgs(brdname, filename, cacheKey, offset).Encode()
 
 	lpArgs := make(url.Values)
 	lpArgs.Set("id", pushstream.GetPushChannel(&pn, config.PushStreamSharedSecret))
@@ -800,12 +1254,136 @@ func uriForPolling(brdname, filename, cacheKey string, offset int) (poll, longPo
 	return
 }
 
+func uriForStreaming(brdname, filename, cacheKey string, offset int) (sse, ws string, err error) {
+	if pushServer == nil {
+		return
+	}
+
+	args := signedOffsetArgs(brdname, filename, cacheKey, offset).Encode()
+	u, err := router.Get("bbsarticlesse").URLPath("brdname", brdname, "filename", filename)
+	if err != nil {
+		return
+	}
+	sse = u.String() + "?" + args
+	u, err = router.Get("bbsarticlews").URLPath("brdname", brdname, "filename", filename)
+	if err != nil {
+		return
+	}
+	ws = u.String() + "?" + args
+	return
+}
+
+func uriForBoardStreaming(brdname string, numPosts int) (sse, ws string, err error) {
+	if pushServer == nil {
+		return
+	}
+
+	args := signedOffsetArgs(brdname, "", "", numPosts)
+	args.Del("cacheKey")
+	u, err := router.Get("bbsindexsse").URLPath("brdname", brdname)
+	if err != nil {
+		return
+	}
+	sse = u.String() + "?" + args.Encode()
+	u, err = router.Get("bbsindexws").URLPath("brdname", brdname)
+	if err != nil {
+		return
+	}
+	ws = u.String() + "?" + args.Encode()
+	return
+}
+
+func signedOffsetArgs(brdname, filename, cacheKey string, offset int) url.Values {
+	pn := pushstream.PushNotification{
+		Brdname:  brdname,
+		Filename: filename,
+		Size:     int64(offset),
+	}
+	pushKeys.Sign(&pn, time.Now())
+
+	args := make(url.Values)
+	args.Set("cacheKey", cacheKey)
+	pn.SetArgs(args, "offset")
+	return args
+}
+
+func handleArticleSSE(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	brd, err := getBoardByName(c, vars["brdname"])
+	if err != nil {
+		return err
+	}
+	return pushServer.HandleSSE(w, c.R, brd.BrdName, vars["filename"])
+}
+
+func handleArticleWebSocket(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	brd, err := getBoardByName(c, vars["brdname"])
+	if err != nil {
+		return err
+	}
+	pushServer.WebSocketHandler(brd.BrdName, vars["filename"]).ServeHTTP(w, c.R)
+	return nil
+}
+
+func handleBoardSSE(c *Context, w http.ResponseWriter) error {
+	brd, err := getBoardByName(c, mux.Vars(c.R)["brdname"])
+	if err != nil {
+		return err
+	}
+	return pushServer.HandleBoardSSE(w, c.R, brd.BrdName)
+}
+
+func handleBoardWebSocket(c *Context, w http.ResponseWriter) error {
+	brd, err := getBoardByName(c, mux.Vars(c.R)["brdname"])
+	if err != nil {
+		return err
+	}
+	pushServer.BoardWebSocketHandler(brd.BrdName).ServeHTTP(w, c.R)
+	return nil
+}
+
+// renderArticleFragment renders appended content for the builtin push stream.
+func renderArticleFragment(brdname, filename, cacheKey string, offset, size int) (*pushstream.Fragment, error) {
+	brd, err := getBoardByNameCached(context.Background(), brdname)
+	if err != nil {
+		return nil, err
+	}
+
+	req := bbsArticlePartRequest(brd, filename, cacheKey, offset)
+	obj, err := cacheMgr.Get(req, ZeroArticlePart, time.Minute, generateArticlePart)
+	if err != nil {
+		return nil, err
+	}
+	ap := obj.(*ArticlePart)
+	// The cached part may predate the growth. Render again without cache.
+	if ap.IsValid && ap.NextOffset < size {
+		if obj, err = generateArticlePart(req); err != nil {
+			return nil, err
+		}
+		ap = obj.(*ArticlePart)
+	}
+
+	if !ap.IsValid {
+		return nil, pttbbs.ErrNotFound
+	}
+	if ap.NextOffset <= offset {
+		return nil, nil
+	}
+	return &pushstream.Fragment{
+		ContentHtml: ap.ContentHtml,
+		CacheKey:    ap.CacheKey,
+		Offset:      offset,
+		NextOffset:  ap.NextOffset,
+	}, nil
+}
+
 func getBoardByName(c *Context, brdname string) (*pttbbs.Board, error) {
 	if !pttbbs.IsValidBrdName(brdname) {
 		return nil, NewNotFoundError(fmt.Errorf("invalid board name: %s", brdname))
 	}
 
-	brd, err := getBoardByNameCached(brdname)
+	brd, err := getBoardByNameCached(c.R.Context(), brdname)
 	if err != nil {
 		return nil, err
 	}
@@ -880,43 +1458,99 @@ func handleMan(c *Context, w http.ResponseWriter) error {
 }
 
 func handleManIndex(c *Context, w http.ResponseWriter, brd *pttbbs.Board, path string) error {
-	res, err := mand.List(context.TODO(), &manpb.ListRequest{
-		BoardName: brd.BrdName,
-		Path:      path,
-	}, grpc.FailFast(true))
+	mi, err := getManIndex(brd, path)
 	if err != nil {
 		return err
 	}
+	if !mi.IsValid {
+		return NewNotFoundError(fmt.Errorf("not a valid man index: %v/%v", brd.BrdName, path))
+	}
+
+	entries := make([]*manpb.Entry, len(mi.Entries))
+	for i, e := range mi.Entries {
+		entries[i] = &manpb.Entry{
+			BoardName: brd.BrdName,
+			Path:      e.Path,
+			Title:     e.Title,
+			IsDir:     e.IsDir,
+		}
+	}
 	return page.ExecutePage(w, &page.ManIndex{
-		Board:   *brd,
-		Path:    path,
-		Entries: res.Entries,
+		Board:       *brd,
+		Path:        path,
+		Entries:     entries,
+		Breadcrumbs: manBreadcrumbs(brd, path, true),
 	})
 }
 
+// manBreadcrumbs resolves titles of each segment of p from listings of the
+// parent directories. Segments failed to resolve are titled by their names.
+func manBreadcrumbs(brd *pttbbs.Board, p string, isDir bool) []page.ManBreadcrumb {
+	if p == "" {
+		return nil
+	}
+	segs := strings.Split(p, "/")
+	crumbs := make([]page.ManBreadcrumb, len(segs))
+	parent := ""
+	for i, seg := range segs {
+		full := path.Join(parent, seg)
+		crumbs[i] = page.ManBreadcrumb{
+			Path:  full,
+			Title: seg,
+			IsDir: isDir || i < len(segs)-1,
+		}
+		if mi, err := getManIndex(brd, parent); err == nil {
+			for _, e := range mi.Entries {
+				if e.Path == full {
+					crumbs[i].Title = e.Title
+					break
+				}
+			}
+		}
+		parent = full
+	}
+	return crumbs
+}
+
+func handleManSearch(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	brd, err := getBoardByName(c, vars["brdname"])
+	if err != nil {
+		return err
+	}
+
+	query := strings.TrimSpace(c.R.FormValue("q"))
+	if utf8.RuneCountInString(query) > MaxManSearchQueryLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	p := &page.ManSearch{
+		Board: *brd,
+		Query: query,
+	}
+	if query != "" {
+		obj, err := cacheMgr.Get(&ManSearchRequest{
+			Brd:   *brd,
+			Query: query,
+		}, ZeroManSearch, ManSearchCacheTimeout, generateManSearch)
+		if err != nil {
+			return err
+		}
+		ms := obj.(*ManSearch)
+		p.Results = ms.Results
+		p.Truncated = ms.Truncated
+	}
+	return page.ExecutePage(w, p)
+}
+
 func handleManArticle(c *Context, w http.ResponseWriter, brd *pttbbs.Board, path string) error {
 	obj, err := cacheMgr.Get(&ArticleRequest{
 		Namespace: "man",
 		Brd:       *brd,
 		Filename:  path,
 		Select: func(m pttbbs.SelectMethod, offset, maxlen int) (*pttbbs.ArticlePart, error) {
-			res, err := mand.Article(context.TODO(), &manpb.ArticleRequest{
-				BoardName:  brd.BrdName,
-				Path:       path,
-				SelectType: manSelectType(m),
-				Offset:     int64(offset),
-				MaxLength:  int64(maxlen),
-			}, grpc.FailFast(true))
-			if err != nil {
-				return nil, err
-			}
-			return &pttbbs.ArticlePart{
-				CacheKey: res.CacheKey,
-				FileSize: int(res.FileSize),
-				Offset:   int(res.SelectedOffset),
-				Length:   int(res.SelectedSize),
-				Content:  res.Content,
-			}, nil
+			return manArticleSelect(context.TODO(), brd, path, m, "", offset, maxlen)
 		},
 	}, ZeroArticle, ArticleCacheTimeout, generateArticle)
 	if err != nil {
@@ -932,17 +1566,248 @@ func handleManArticle(c *Context, w http.ResponseWriter, brd *pttbbs.Board, path
 		log.Println("Large rendered article:", brd.BrdName, path, len(ar.ContentHtml))
 	}
 
+	etag := makeETag("man", brd.BrdName, path, ar.CacheKey, strconv.Itoa(ar.NextOffset),
+		strconv.FormatInt(pushKeys.ExpireAt(time.Now()), 10))
+	if checkNotModified(c, w, etag, time.Time{}, ArticleCacheTimeout, brd.Over18) {
+		return nil
+	}
+
+	// Truncated articles continue from the end of the head, and readers
+	// load the rest, including the tail, by polling.
+	offset, tail := ar.NextOffset, ar.ContentTailHtml
+	if ar.IsTruncated {
+		offset = ar.MiddleOffset
+	}
+	pollUrl, err := uriForManPolling(brd.BrdName, path, ar.CacheKey, offset)
+	if err != nil {
+		return err
+	}
+	if pollUrl == "" {
+		offset = ar.NextOffset
+	} else if ar.IsTruncated {
+		tail = nil
+	}
+
 	return page.ExecutePage(w, &page.ManArticle{
 		Title:            ar.ParsedTitle,
 		Description:      ar.PreviewContent,
 		Board:            brd,
 		Path:             path,
 		Content:          template.HTML(string(ar.ContentHtml)),
-		ContentTail:      template.HTML(string(ar.ContentTailHtml)),
+		ContentTail:      template.HTML(string(tail)),
 		ContentTruncated: ar.IsTruncated,
+		PollUrl:          pollUrl,
+		CurrOffset:       offset,
+		Breadcrumbs:      manBreadcrumbs(brd, path, false),
 	})
 }
 
+// manSignedName is the filename signing offsets of a man article, apart from
+// those of bbs articles.
+func manSignedName(path string) string {
+	return "man/" + path
+}
+
+func manArticlePartRequest(brd *pttbbs.Board, path, cacheKey string, offset int) *ArticlePartRequest {
+	return &ArticlePartRequest{
+		Namespace: "man",
+		Brd:       *brd,
+		Filename:  path,
+		CacheKey:  cacheKey,
+		Offset:    offset,
+		Select: func(cacheKey string, offset int) (*pttbbs.ArticlePart, error) {
+			return manArticleSelect(context.TODO(), brd, path, pttbbs.SelectPart, cacheKey, offset, ManArticlePartSize)
+		},
+	}
+}
+
+// handleManArticlePoll returns content of a man article after the signed
+// offset, up to ManArticlePartSize. Readers continue from PollUrl of the
+// response to load more, or to follow growth of the article.
+func handleManArticlePoll(c *Context, w http.ResponseWriter) error {
+	vars := mux.Vars(c.R)
+	fullpath := strings.SplitN(vars["fullpath"], "/", 2)
+	if len(fullpath) < 2 {
+		return NewNotFoundError(fmt.Errorf("invalid path: %v", fullpath))
+	}
+	brdname, path := fullpath[0], fullpath[1]
+
+	offset, err := verifySignedArg(c, brdname, manSignedName(path), "offset")
+	switch err {
+	case nil:
+	case ErrBadSignedArg:
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	case pushstream.ErrSigMismatch, pushstream.ErrSigExpired:
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	default:
+		return err
+	}
+
+	brd, err := getBoardByName(c, brdname)
+	if err != nil {
+		return err
+	}
+
+	obj, err := cacheMgr.Get(manArticlePartRequest(brd, path, c.R.FormValue("cacheKey"), offset), ZeroArticlePart, time.Minute, generateArticlePart)
+	if err != nil {
+		return err
+	}
+	ap := obj.(*ArticlePart)
+
+	res := new(page.ArticlePollResp)
+	res.Success = ap.IsValid
+	if ap.IsValid {
+		res.ContentHtml = ap.ContentHtml
+		res.HasMore = ap.HasMore
+		res.PollUrl, err = uriForManPolling(brdname, path, ap.CacheKey, ap.NextOffset)
+		if err != nil {
+			return err
+		}
+	}
+	return page.WriteAjaxResp(w, res)
+}
+
+func uriForManPolling(brdname, path, cacheKey string, offset int) (string, error) {
+	if !pushKeys.CanSign() {
+		return "", nil
+	}
+	u, err := router.Get("manarticlepoll").URLPath("fullpath", brdname+"/"+path)
+	if err != nil {
+		return "", err
+	}
+	return u.String() + "?" + signedOffsetArgs(brdname, manSignedName(path), cacheKey, offset).Encode(), nil
+}
+
+// renderBoardFragment returns new posts for the builtin push stream.
+func renderBoardFragment(brdname string, offset, size int) (*pushstream.BoardFragment, error) {
+	brd, err := getBoardByNameCached(context.Background(), brdname)
+	if err != nil {
+		return nil, err
+	}
+
+	if size-offset > BoardFragmentMaxPosts {
+		offset = size - BoardFragmentMaxPosts
+	}
+	var articles []pttbbs.Article
+	for next := offset; next < size; {
+		n := size - next
+		if n > EntryPerPage {
+			n = EntryPerPage
+		}
+		page, err := ptt.GetArticleList(brd.Ref(), next, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		articles = append(articles, page...)
+		next += len(page)
+	}
+	if len(articles) == 0 {
+		return nil, nil
+	}
+
+	frag := &pushstream.BoardFragment{
+		Offset:     offset,
+		NextOffset: offset + len(articles),
+	}
+	for _, a := range articles {
+		row := &pushstream.ArticleRow{
+			FileName:  a.FileName,
+			Title:     a.Title,
+			Owner:     a.Owner,
+			Date:      a.Date,
+			Recommend: a.Recommend,
+			FileMode:  a.FileMode,
+		}
+		if pttbbs.IsValidArticleFileName(a.FileName) {
+			u, err := router.Get("bbsarticle").URLPath("brdname", brd.BrdName, "filename", a.FileName)
+			if err != nil {
+				return nil, err
+			}
+			row.Url = u.String()
+		}
+		frag.Articles = append(frag.Articles, row)
+	}
+	return frag, nil
+}
+
+// manSelectPartUnsupported is set once mand turns out not to implement
+// SELECT_PART, after which parts are cut from full articles instead.
+var manSelectPartUnsupported int32
+
+// manArticleSelect reads part of a man article from mand.
+func manArticleSelect(ctx context.Context, brd *pttbbs.Board, path string, m pttbbs.SelectMethod, cacheKey string, offset, maxlen int) (*pttbbs.ArticlePart, error) {
+	if m == pttbbs.SelectPart && atomic.LoadInt32(&manSelectPartUnsupported) != 0 {
+		return manArticleSelectFull(ctx, brd, path, cacheKey, offset, maxlen)
+	}
+	res, err := mand.Article(ctx, &manpb.ArticleRequest{
+		BoardName:  brd.BrdName,
+		Path:       path,
+		SelectType: manSelectType(m),
+		CacheKey:   cacheKey,
+		Offset:     int64(offset),
+		MaxLength:  int64(maxlen),
+	}, grpc.FailFast(true))
+	if m == pttbbs.SelectPart {
+		switch grpc.Code(err) {
+		case codes.Unimplemented, codes.InvalidArgument:
+			log.Println("mand does not implement SELECT_PART, using SELECT_FULL:", err)
+			atomic.StoreInt32(&manSelectPartUnsupported, 1)
+			return manArticleSelectFull(ctx, brd, path, cacheKey, offset, maxlen)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pttbbs.ArticlePart{
+		CacheKey: res.CacheKey,
+		FileSize: int(res.FileSize),
+		Offset:   int(res.SelectedOffset),
+		Length:   int(res.SelectedSize),
+		Content:  res.Content,
+	}, nil
+}
+
+// manArticleSelectFull reads the full man article, and returns up to maxlen
+// bytes of it from offset, as SELECT_PART would. The part is cut at
+// boundaries of UTF-8 characters.
+func manArticleSelectFull(ctx context.Context, brd *pttbbs.Board, path, cacheKey string, offset, maxlen int) (*pttbbs.ArticlePart, error) {
+	res, err := mand.Article(ctx, &manpb.ArticleRequest{
+		BoardName:  brd.BrdName,
+		Path:       path,
+		SelectType: manpb.ArticleRequest_SELECT_FULL,
+		CacheKey:   cacheKey,
+	}, grpc.FailFast(true))
+	if err != nil {
+		return nil, err
+	}
+	content := res.Content
+	begin, end := offset, offset+maxlen
+	if begin > len(content) {
+		begin = len(content)
+	}
+	if end > len(content) {
+		end = len(content)
+	}
+	for begin < end && !utf8.RuneStart(content[begin]) {
+		begin++
+	}
+	for end > begin && end < len(content) && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	return &pttbbs.ArticlePart{
+		CacheKey: res.CacheKey,
+		FileSize: int(res.FileSize),
+		Offset:   begin - offset,
+		Length:   end - begin,
+		Content:  content[begin:end],
+	}, nil
+}
+
 func manSelectType(m pttbbs.SelectMethod) manpb.ArticleRequest_SelectType {
 	switch m {
 	case pttbbs.SelectHead:
@@ -950,7 +1815,7 @@ func manSelectType(m pttbbs.SelectMethod) manpb.ArticleRequest_SelectType {
 	case pttbbs.SelectTail:
 		return manpb.ArticleRequest_SELECT_TAIL
 	case pttbbs.SelectPart:
-		return manpb.ArticleRequest_SELECT_FULL
+		return manpb.ArticleRequest_SELECT_PART
 	default:
 		panic("unknown select type")
 	}
@@ -0,0 +1,141 @@
+package pushstream
+
+import (
+	"sync"
+)
+
+// Broker delivers push notifications to in-process subscribers by channel.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives notifications published to a channel.
+type Subscription struct {
+	// C delivers notifications. Notifications are coalesced when the
+	// subscriber is slow, so only the latest one is guaranteed to arrive.
+	C <-chan *PushNotification
+
+	c       chan *PushNotification
+	channel string
+	b       *Broker
+}
+
+// Subscribe subscribes to a channel. The subscription must be closed by
+// calling Close.
+func (b *Broker) Subscribe(channel string) *Subscription {
+	c := make(chan *PushNotification, 1)
+	s := &Subscription{
+		C:       c,
+		c:       c,
+		channel: channel,
+		b:       b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[channel] == nil {
+		b.subs[channel] = make(map[*Subscription]struct{})
+	}
+	b.subs[channel][s] = struct{}{}
+	return s
+}
+
+// Publish sends a notification to all subscribers of the channel. It never
+// blocks.
+func (b *Broker) Publish(channel string, pn *PushNotification) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if pn.memo == nil {
+		pn.memo = new(renderMemo)
+	}
+	for s := range b.subs[channel] {
+		s.offer(pn)
+	}
+}
+
+// NumSubscribers returns the number of subscribers of the channel.
+func (b *Broker) NumSubscribers(channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs[channel])
+}
+
+func (b *Broker) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.subs[s.channel]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.subs, s.channel)
+	}
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.b.unsubscribe(s)
+}
+
+func (s *Subscription) offer(pn *PushNotification) {
+	for {
+		select {
+		case s.c <- pn:
+			return
+		default:
+		}
+		// Drop the stale one and retry.
+		select {
+		case <-s.c:
+		default:
+		}
+	}
+}
+
+// renderMemo shares what is rendered for a notification among subscribers,
+// so that subscribers in the same state render once.
+type renderMemo struct {
+	mu      sync.Mutex
+	renders map[string]*memoRender
+}
+
+type memoRender struct {
+	done chan struct{}
+	msg  interface{}
+	err  error
+}
+
+// do returns the result of f for key, calling f once per notification.
+// Notifications not published by a broker call f every time.
+func (pn *PushNotification) do(key string, f func() (interface{}, error)) (interface{}, error) {
+	m := pn.memo
+	if m == nil {
+		return f()
+	}
+
+	m.mu.Lock()
+	if r, ok := m.renders[key]; ok {
+		m.mu.Unlock()
+		<-r.done
+		return r.msg, r.err
+	}
+	if m.renders == nil {
+		m.renders = make(map[string]*memoRender)
+	}
+	r := &memoRender{done: make(chan struct{})}
+	m.renders[key] = r
+	m.mu.Unlock()
+
+	r.msg, r.err = f()
+	close(r.done)
+	return r.msg, r.err
+}
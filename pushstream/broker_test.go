@@ -0,0 +1,83 @@
+package pushstream
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	s1 := b.Subscribe("a")
+	s2 := b.Subscribe("a")
+	s3 := b.Subscribe("b")
+	defer s3.Close()
+
+	if n := b.NumSubscribers("a"); n != 2 {
+		t.Errorf("NumSubscribers(a) = %v, want 2", n)
+	}
+
+	// Slow subscribers get the latest one only.
+	b.Publish("a", &PushNotification{Size: 1})
+	b.Publish("a", &PushNotification{Size: 2})
+	for i, s := range []*Subscription{s1, s2} {
+		select {
+		case pn := <-s.C:
+			if pn.Size != 2 {
+				t.Errorf("#%v: got Size = %v, want 2", i, pn.Size)
+			}
+		default:
+			t.Errorf("#%v: got nothing, want a notification", i)
+		}
+	}
+	select {
+	case pn := <-s3.C:
+		t.Errorf("got %v on channel b, want nothing", pn)
+	default:
+	}
+
+	s1.Close()
+	s2.Close()
+	if n := b.NumSubscribers("a"); n != 0 {
+		t.Errorf("NumSubscribers(a) = %v, want 0", n)
+	}
+}
+
+func TestBrokerSharesRenders(t *testing.T) {
+	var mu sync.Mutex
+	renders := 0
+	s := NewServer("secret", "", nil, func(brdname, filename, cacheKey string, offset, size int) (*Fragment, error) {
+		mu.Lock()
+		renders++
+		mu.Unlock()
+		return &Fragment{Offset: offset, NextOffset: size}, nil
+	}, nil)
+
+	var streams []*articleStream
+	for i := 0; i < 10; i++ {
+		streams = append(streams, &articleStream{s: s, brdname: "a", filename: "f", offset: 10})
+	}
+	streams = append(streams, &articleStream{s: s, brdname: "a", filename: "f", offset: 5})
+
+	pn := &PushNotification{Brdname: "a", Filename: "f", Size: 20}
+	s.broker.Publish(streams[0].channel(), pn)
+
+	var wg sync.WaitGroup
+	for _, st := range streams {
+		wg.Add(1)
+		go func(st *articleStream) {
+			defer wg.Done()
+			if msg, err := st.next(pn); err != nil || msg == nil {
+				t.Errorf("next() = %v, %v", msg, err)
+			}
+		}(st)
+	}
+	wg.Wait()
+	if renders != 2 {
+		t.Errorf("renders = %v, want 2 for the two offsets", renders)
+	}
+	for _, st := range streams {
+		if st.offset != 20 {
+			t.Errorf("offset = %v, want 20", st.offset)
+		}
+	}
+}
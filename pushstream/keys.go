@@ -0,0 +1,145 @@
+package pushstream
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultSignatureTTL = 6 * time.Hour
+)
+
+var (
+	ErrSigExpired = errors.New("push stream signature expired")
+)
+
+// Keys signs and verifies push notifications with HMAC-SHA256. Signatures
+// carry an expiry time and the id of the key, so keys can be rotated by
+// adding a new key, making it active, and removing the old one after TTL.
+type Keys struct {
+	// ActiveKeyID is the key to sign with. If empty, the legacy scheme
+	// with LegacySecret is used for signing and verifying.
+	ActiveKeyID string
+
+	// Secrets maps key ids to secrets. All of them are accepted.
+	Secrets map[string]string
+
+	// TTL is how long signatures are valid.
+	TTL time.Duration
+
+	// LegacySecret is the secret of the legacy scheme. When ActiveKeyID
+	// is set, legacy signatures are still accepted before
+	// AcceptLegacyUntil for migration.
+	LegacySecret      string
+	AcceptLegacyUntil time.Time
+}
+
+// ExpireAt returns the expiry time of signatures made at now. It is snapped
+// to a quarter of TTL so that signatures are stable for a while.
+func (k *Keys) ExpireAt(now time.Time) int64 {
+	if k.ActiveKeyID == "" {
+		return 0
+	}
+	step := int64(k.ttl()/time.Second) / 4
+	if step < 1 {
+		step = 1
+	}
+	exp := now.Add(k.ttl()).Unix()
+	return (exp + step - 1) / step * step
+}
+
+// CanSign tells if signatures can't be forged without a secret, i.e. the
+// secret to sign with is not empty.
+func (k *Keys) CanSign() bool {
+	if k.ActiveKeyID == "" {
+		return k.LegacySecret != ""
+	}
+	return k.Secrets[k.ActiveKeyID] != ""
+}
+
+func (k *Keys) ttl() time.Duration {
+	if k.TTL <= 0 {
+		return DefaultSignatureTTL
+	}
+	return k.TTL
+}
+
+// Sign signs the notification with the active key.
+func (k *Keys) Sign(p *PushNotification, now time.Time) {
+	if k.ActiveKeyID == "" {
+		p.Expire = 0
+		p.KeyID = ""
+		p.Sign(k.LegacySecret)
+		return
+	}
+	p.Expire = k.ExpireAt(now)
+	p.KeyID = k.ActiveKeyID
+	p.Signature = p.calcHMAC(k.Secrets[k.ActiveKeyID])
+}
+
+// Verify checks the signature and expiry of the notification.
+func (k *Keys) Verify(p *PushNotification, now time.Time) error {
+	if p.KeyID == "" {
+		if k.ActiveKeyID != "" && !now.Before(k.AcceptLegacyUntil) {
+			return ErrSigMismatch
+		}
+		if !p.CheckSignature(k.LegacySecret) {
+			return ErrSigMismatch
+		}
+		return nil
+	}
+
+	secret, ok := k.Secrets[p.KeyID]
+	if !ok {
+		return ErrSigMismatch
+	}
+	if !hmac.Equal([]byte(p.Signature), []byte(p.calcHMAC(secret))) {
+		return ErrSigMismatch
+	}
+	if now.Unix() >= p.Expire {
+		return ErrSigExpired
+	}
+	return nil
+}
+
+// SetArgs sets the signed size of the notification in args. name is the
+// parameter name of the size, e.g. "offset".
+func (p *PushNotification) SetArgs(args url.Values, name string) {
+	args.Set(name, strconv.FormatInt(p.Size, 10))
+	args.Set(name+"-sig", p.Signature)
+	if p.KeyID != "" {
+		args.Set(name+"-exp", strconv.FormatInt(p.Expire, 10))
+		args.Set(name+"-kid", p.KeyID)
+	}
+}
+
+// ParseArgs reads the signed size set by SetArgs from args. The signature
+// is not verified.
+func (p *PushNotification) ParseArgs(args url.Values, name string) error {
+	size, err := strconv.ParseInt(args.Get(name), 10, 64)
+	if err != nil {
+		return err
+	}
+	p.Size = size
+	p.Signature = args.Get(name + "-sig")
+	p.KeyID = args.Get(name + "-kid")
+	p.Expire = 0
+	if p.KeyID != "" {
+		if p.Expire, err = strconv.ParseInt(args.Get(name+"-exp"), 10, 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PushNotification) calcHMAC(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%v/%v/%v/%v/%v", p.KeyID, p.Brdname, p.Filename, p.Size, p.Expire)
+	return hex.EncodeToString(mac.Sum(nil))
+}
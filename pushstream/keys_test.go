@@ -0,0 +1,98 @@
+package pushstream
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestKeysSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	keys := &Keys{
+		ActiveKeyID: "k2",
+		Secrets:     map[string]string{"k1": "old", "k2": "new"},
+		TTL:         time.Hour,
+	}
+
+	pn := &PushNotification{Brdname: "Test", Filename: "M.1.A.2", Size: 123}
+	keys.Sign(pn, now)
+	if pn.KeyID != "k2" || pn.Expire < now.Add(time.Hour).Unix() {
+		t.Fatalf("unexpected key id or expiry: %+v", pn)
+	}
+	if err := keys.Verify(pn, now); err != nil {
+		t.Error("verify:", err)
+	}
+
+	args := make(url.Values)
+	pn.SetArgs(args, "offset")
+	parsed := &PushNotification{Brdname: "Test", Filename: "M.1.A.2"}
+	if err := parsed.ParseArgs(args, "offset"); err != nil {
+		t.Fatal("parse args:", err)
+	}
+	if err := keys.Verify(parsed, now); err != nil {
+		t.Error("verify parsed:", err)
+	}
+
+	if err := keys.Verify(parsed, now.Add(2*time.Hour)); err != ErrSigExpired {
+		t.Error("expected expired, got", err)
+	}
+
+	tampered := *parsed
+	tampered.Size++
+	if err := keys.Verify(&tampered, now); err != ErrSigMismatch {
+		t.Error("expected mismatch for tampered size, got", err)
+	}
+
+	tampered = *parsed
+	tampered.Expire += 3600
+	if err := keys.Verify(&tampered, now); err != ErrSigMismatch {
+		t.Error("expected mismatch for tampered expiry, got", err)
+	}
+
+	// Signatures of retired keys are rejected.
+	delete(keys.Secrets, "k2")
+	if err := keys.Verify(parsed, now); err != ErrSigMismatch {
+		t.Error("expected mismatch for removed key, got", err)
+	}
+}
+
+func TestKeysLegacy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	legacy := &PushNotification{Brdname: "Test", Filename: "M.1.A.2", Size: 123}
+	legacy.Sign("secret")
+
+	keys := &Keys{LegacySecret: "secret"}
+	if err := keys.Verify(legacy, now); err != nil {
+		t.Error("verify legacy without keys:", err)
+	}
+
+	keys = &Keys{
+		ActiveKeyID:       "k1",
+		Secrets:           map[string]string{"k1": "new"},
+		LegacySecret:      "secret",
+		AcceptLegacyUntil: now.Add(time.Hour),
+	}
+	if err := keys.Verify(legacy, now); err != nil {
+		t.Error("verify legacy in migration window:", err)
+	}
+	if err := keys.Verify(legacy, now.Add(time.Hour)); err != ErrSigMismatch {
+		t.Error("expected mismatch after migration window, got", err)
+	}
+}
+
+func TestKeysCanSign(t *testing.T) {
+	for _, tc := range []struct {
+		keys Keys
+		want bool
+	}{
+		{Keys{}, false},
+		{Keys{LegacySecret: "secret"}, true},
+		{Keys{ActiveKeyID: "k1", LegacySecret: "secret"}, false},
+		{Keys{ActiveKeyID: "k1", Secrets: map[string]string{"k1": ""}}, false},
+		{Keys{ActiveKeyID: "k1", Secrets: map[string]string{"k1": "new"}}, true},
+	} {
+		if got := tc.keys.CanSign(); got != tc.want {
+			t.Errorf("%+v: CanSign() = %v, want %v", tc.keys, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package pushstream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// PublishSignatureHeader carries the signature of publish requests made by
+// SignPublish.
+const PublishSignatureHeader = "X-Pushstream-Signature"
+
+// SignPublish signs the body of a publish request with the secret of
+// publishers. Signatures are domain separated from those given to readers,
+// and cover the whole notification, e.g. CacheKey.
+func SignPublish(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("publish/"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publisher delivers notifications to subscribers of a channel.
+type Publisher interface {
+	Publish(channel string, pn *PushNotification) error
+}
+
+// HTTPPublisher publishes notifications as JSON by POST requests. It works
+// with the publisher location of nginx push-stream module, which takes the
+// channel in the "id" parameter, and with Server.HandlePublish.
+type HTTPPublisher struct {
+	URL    string
+	Client *http.Client
+	// Secret, if set, signs requests for Server.HandlePublish.
+	Secret string
+}
+
+func (p *HTTPPublisher) Publish(channel string, pn *PushNotification) error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("id", channel)
+	u.RawQuery = q.Encode()
+
+	body, err := json.Marshal(pn)
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Secret != "" {
+		req.Header.Set(PublishSignatureHeader, SignPublish(p.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pushstream: publish to %v: %v", p.URL, resp.Status)
+	}
+	return nil
+}
+
+// BrokerPublisher publishes notifications to an in-process broker.
+type BrokerPublisher struct {
+	Broker *Broker
+}
+
+func (p *BrokerPublisher) Publish(channel string, pn *PushNotification) error {
+	p.Broker.Publish(channel, pn)
+	return nil
+}
+
+// Notifier signs notifications of growing articles and boards and publishes
+// them to their channels.
+type Notifier struct {
+	// Secret derives channel names.
+	Secret    string
+	Keys      *Keys
+	Publisher Publisher
+}
+
+// NotifyArticle notifies that an article has grown to size bytes.
+func (n *Notifier) NotifyArticle(brdname, filename, cacheKey string, size int64) error {
+	pn := &PushNotification{
+		Brdname:  brdname,
+		Filename: filename,
+		Size:     size,
+		CacheKey: cacheKey,
+	}
+	n.Keys.Sign(pn, time.Now())
+	return n.Publisher.Publish(GetPushChannel(pn, n.Secret), pn)
+}
+
+// NotifyBoard notifies that a board has numPosts posts.
+func (n *Notifier) NotifyBoard(brdname string, numPosts int) error {
+	pn := &PushNotification{
+		Brdname: brdname,
+		Size:    int64(numPosts),
+	}
+	n.Keys.Sign(pn, time.Now())
+	return n.Publisher.Publish(GetBoardPushChannel(brdname, n.Secret), pn)
+}
@@ -0,0 +1,59 @@
+package pushstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifierToServer(t *testing.T) {
+	keys := &Keys{
+		ActiveKeyID: "k1",
+		Secrets:     map[string]string{"k1": "key"},
+	}
+	s := NewServer("secret", "publish", keys, nil, nil)
+	ts := httptest.NewServer(http.HandlerFunc(s.HandlePublish))
+	defer ts.Close()
+
+	ch := GetPushChannel(&PushNotification{Brdname: "Test", Filename: "M.1.A.2"}, "secret")
+	sub := s.Broker().Subscribe(ch)
+	defer sub.Close()
+
+	n := &Notifier{
+		Secret:    "secret",
+		Keys:      keys,
+		Publisher: &HTTPPublisher{URL: ts.URL, Secret: "publish"},
+	}
+	if err := n.NotifyArticle("Test", "M.1.A.2", "ck", 456); err != nil {
+		t.Fatal("notify:", err)
+	}
+
+	select {
+	case pn := <-sub.C:
+		if pn.Size != 456 || pn.CacheKey != "ck" {
+			t.Errorf("unexpected notification: %+v", pn)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not delivered")
+	}
+
+	// Signatures given to readers don't authorize publishing.
+	pn := &PushNotification{Brdname: "Test", Filename: "M.1.A.2", Size: 789, CacheKey: "ck"}
+	keys.Sign(pn, time.Now())
+	if err := (&HTTPPublisher{URL: ts.URL}).Publish(ch, pn); err == nil {
+		t.Error("expected unsigned publish to be rejected")
+	}
+	if err := (&HTTPPublisher{URL: ts.URL, Secret: "other"}).Publish(ch, pn); err == nil {
+		t.Error("expected publish signed with another secret to be rejected")
+	}
+
+	// Notifications signed with unknown keys are rejected.
+	n.Keys = &Keys{
+		ActiveKeyID: "k2",
+		Secrets:     map[string]string{"k2": "other"},
+	}
+	if err := n.NotifyArticle("Test", "M.1.A.2", "ck", 789); err == nil {
+		t.Error("expected publish to be rejected")
+	}
+}
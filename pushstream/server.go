@@ -0,0 +1,438 @@
+package pushstream
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// MaxPublishBodySize limits the size of a publish request.
+	MaxPublishBodySize = 4096
+
+	// KeepAliveInterval is the interval of keep-alive messages sent to idle
+	// subscribers.
+	KeepAliveInterval = 30 * time.Second
+)
+
+var (
+	ErrBadSubscribeParams = errors.New("bad subscribe parameters")
+	ErrSigMismatch        = errors.New("push stream signature mismatch")
+)
+
+// Fragment is the newly appended content of an article since Offset.
+type Fragment struct {
+	ContentHtml string `json:"contentHtml"`
+	CacheKey    string `json:"cacheKey"`
+	Offset      int    `json:"offset"`
+	NextOffset  int    `json:"nextOffset"`
+}
+
+// BoardFragment is the posts appended to a board since Offset. Rows may
+// overlap what the reader has seen, so readers should skip known filenames.
+// Offset may be past that of readers far behind.
+type BoardFragment struct {
+	Articles   []*ArticleRow `json:"articles"`
+	Offset     int           `json:"offset"`
+	NextOffset int           `json:"nextOffset"`
+}
+
+type ArticleRow struct {
+	FileName  string `json:"filename"`
+	Url       string `json:"url"`
+	Title     string `json:"title"`
+	Owner     string `json:"owner"`
+	Date      string `json:"date"`
+	Recommend int    `json:"recommend"`
+	FileMode  int    `json:"filemode"`
+}
+
+// RenderFunc renders the content of an article starting at offset, which is
+// known to have grown to at least size. A nil fragment indicates there is
+// nothing to render. It is called once per notification for subscribers
+// at the same offset.
+type RenderFunc func(brdname, filename, cacheKey string, offset, size int) (*Fragment, error)
+
+// BoardRenderFunc returns posts of a board starting at offset, which is
+// known to have grown to at least size. A nil fragment indicates there is
+// nothing new. It is called once per notification for subscribers at the
+// same offset.
+type BoardRenderFunc func(brdname string, offset, size int) (*BoardFragment, error)
+
+// Server serves live article and board updates over Server-Sent Events and
+// WebSocket, and accepts signed notifications from publishers.
+type Server struct {
+	secret        string
+	publishSecret string
+	keys          *Keys
+	render        RenderFunc
+	renderBoard   BoardRenderFunc
+	broker        *Broker
+
+	mu      sync.Mutex
+	watched map[string]int
+}
+
+// NewServer creates a server. secret derives channel names, publishSecret
+// authenticates publishers, and keys verify signed offsets and
+// notifications.
+func NewServer(secret, publishSecret string, keys *Keys, render RenderFunc, renderBoard BoardRenderFunc) *Server {
+	return &Server{
+		secret:        secret,
+		publishSecret: publishSecret,
+		keys:          keys,
+		render:        render,
+		renderBoard:   renderBoard,
+		broker:        NewBroker(),
+		watched:       make(map[string]int),
+	}
+}
+
+// Broker returns the broker delivering notifications to subscribers.
+func (s *Server) Broker() *Broker {
+	return s.broker
+}
+
+// HandlePublish accepts a signed PushNotification in JSON. Requests must be
+// signed by SignPublish with the secret of publishers, as signatures of
+// notifications are also given to readers. Notifications without a filename
+// are for new posts of a board, and Size is the number of posts.
+func (s *Server) HandlePublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxPublishBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sig := r.Header.Get(PublishSignatureHeader)
+	if s.publishSecret == "" || !hmac.Equal([]byte(sig), []byte(SignPublish(s.publishSecret, body))) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var pn PushNotification
+	if err := json.Unmarshal(body, &pn); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := s.keys.Verify(&pn, time.Now()); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	s.broker.Publish(GetPushChannel(&pn, s.secret), &pn)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WatchBoards polls number of posts of boards having subscribers every
+// interval, and publishes to them when it changes. It is for publishers not
+// notifying new posts, and never returns.
+func (s *Server) WatchBoards(interval time.Duration, numPosts func(brdname string) (int, error)) {
+	last := make(map[string]int)
+	for range time.Tick(interval) {
+		s.mu.Lock()
+		var boards []string
+		for brdname := range s.watched {
+			boards = append(boards, brdname)
+		}
+		s.mu.Unlock()
+
+		seen := make(map[string]int)
+		for _, brdname := range boards {
+			n, err := numPosts(brdname)
+			if err != nil {
+				log.Println("pushstream: WatchBoards:", brdname, err)
+				continue
+			}
+			seen[brdname] = n
+			if prev, ok := last[brdname]; !ok || n != prev {
+				s.broker.Publish(GetBoardPushChannel(brdname, s.secret), &PushNotification{
+					Brdname: brdname,
+					Size:    int64(n),
+				})
+			}
+		}
+		last = seen
+	}
+}
+
+func (s *Server) watch(brdname string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watched[brdname]++
+}
+
+func (s *Server) unwatch(brdname string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watched[brdname]--; s.watched[brdname] <= 0 {
+		delete(s.watched, brdname)
+	}
+}
+
+// stream follows updates of one channel.
+type stream interface {
+	channel() string
+	event() string
+	// next returns the message for the notification, or nil if there is
+	// nothing new.
+	next(pn *PushNotification) (interface{}, error)
+}
+
+type articleStream struct {
+	s        *Server
+	brdname  string
+	filename string
+	cacheKey string
+	offset   int
+}
+
+func (a *articleStream) channel() string {
+	return GetPushChannel(&PushNotification{
+		Brdname:  a.brdname,
+		Filename: a.filename,
+	}, a.s.secret)
+}
+
+func (a *articleStream) event() string {
+	return "article"
+}
+
+func (a *articleStream) next(pn *PushNotification) (interface{}, error) {
+	if pn.Size <= int64(a.offset) {
+		return nil, nil
+	}
+	msg, err := pn.do(fmt.Sprintf("%v,%v", a.cacheKey, a.offset), func() (interface{}, error) {
+		return a.s.render(a.brdname, a.filename, a.cacheKey, a.offset, int(pn.Size))
+	})
+	frag, _ := msg.(*Fragment)
+	if err != nil || frag == nil {
+		return nil, err
+	}
+	a.cacheKey = frag.CacheKey
+	a.offset = frag.NextOffset
+	return frag, nil
+}
+
+type boardStream struct {
+	s       *Server
+	brdname string
+	offset  int
+}
+
+func (b *boardStream) channel() string {
+	return GetBoardPushChannel(b.brdname, b.s.secret)
+}
+
+func (b *boardStream) event() string {
+	return "board"
+}
+
+func (b *boardStream) next(pn *PushNotification) (interface{}, error) {
+	if pn.Size <= int64(b.offset) {
+		return nil, nil
+	}
+	msg, err := pn.do(fmt.Sprint(b.offset), func() (interface{}, error) {
+		return b.s.renderBoard(b.brdname, b.offset, int(pn.Size))
+	})
+	frag, _ := msg.(*BoardFragment)
+	if err != nil || frag == nil {
+		return nil, err
+	}
+	b.offset = frag.NextOffset
+	return frag, nil
+}
+
+// parseSignedOffset reads the offset as given out in poll urls.
+func (s *Server) parseSignedOffset(r *http.Request, brdname, filename string) (int, error) {
+	pn := &PushNotification{
+		Brdname:  brdname,
+		Filename: filename,
+	}
+	if err := r.ParseForm(); err != nil {
+		return 0, ErrBadSubscribeParams
+	}
+	if err := pn.ParseArgs(r.Form, "offset"); err != nil {
+		return 0, ErrBadSubscribeParams
+	}
+	if err := s.keys.Verify(pn, time.Now()); err != nil {
+		return 0, err
+	}
+	return int(pn.Size), nil
+}
+
+func (s *Server) articleStream(r *http.Request, brdname, filename string) (stream, error) {
+	offset, err := s.parseSignedOffset(r, brdname, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &articleStream{
+		s:        s,
+		brdname:  brdname,
+		filename: filename,
+		cacheKey: r.FormValue("cacheKey"),
+		offset:   offset,
+	}, nil
+}
+
+func (s *Server) boardStream(r *http.Request, brdname string) (stream, error) {
+	offset, err := s.parseSignedOffset(r, brdname, "")
+	if err != nil {
+		return nil, err
+	}
+	return &boardStream{
+		s:       s,
+		brdname: brdname,
+		offset:  offset,
+	}, nil
+}
+
+// follow sends messages of the stream until done is closed or sending
+// fails. keepAlive is called when idle.
+func (s *Server) follow(st stream, done <-chan struct{}, send func(msg interface{}) error, keepAlive func() error) error {
+	ps := s.broker.Subscribe(st.channel())
+	defer ps.Close()
+
+	ticker := time.NewTicker(KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		case pn := <-ps.C:
+			msg, err := st.next(pn)
+			if err != nil {
+				return err
+			}
+			if msg == nil {
+				continue
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// HandleSSE streams fragments of an article as Server-Sent Events.
+func (s *Server) HandleSSE(w http.ResponseWriter, r *http.Request, brdname, filename string) error {
+	st, err := s.articleStream(r, brdname, filename)
+	if err != nil {
+		return err
+	}
+	return s.serveSSE(w, r, st)
+}
+
+// HandleBoardSSE streams new posts of a board as Server-Sent Events.
+func (s *Server) HandleBoardSSE(w http.ResponseWriter, r *http.Request, brdname string) error {
+	st, err := s.boardStream(r, brdname)
+	if err != nil {
+		return err
+	}
+	s.watch(brdname)
+	defer s.unwatch(brdname)
+	return s.serveSSE(w, r, st)
+}
+
+func (s *Server) serveSSE(w http.ResponseWriter, r *http.Request, st stream) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming unsupported")
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Disable buffering of nginx in front of us.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(msg interface{}) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", st.event(), data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	keepAlive := func() error {
+		if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := s.follow(st, r.Context().Done(), send, keepAlive); err != nil {
+		log.Println("pushstream: sse:", err)
+	}
+	return nil
+}
+
+// WebSocketHandler returns a handler streaming fragments of an article as
+// JSON messages over WebSocket.
+func (s *Server) WebSocketHandler(brdname, filename string) http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		st, err := s.articleStream(ws.Request(), brdname, filename)
+		if err != nil {
+			return
+		}
+		s.serveWebSocket(ws, st)
+	})
+}
+
+// BoardWebSocketHandler returns a handler streaming new posts of a board as
+// JSON messages over WebSocket.
+func (s *Server) BoardWebSocketHandler(brdname string) http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		st, err := s.boardStream(ws.Request(), brdname)
+		if err != nil {
+			return
+		}
+		s.watch(brdname)
+		defer s.unwatch(brdname)
+		s.serveWebSocket(ws, st)
+	})
+}
+
+func (s *Server) serveWebSocket(ws *websocket.Conn, st stream) {
+	// Readers don't send anything; reading only detects closing.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	send := func(msg interface{}) error {
+		return websocket.JSON.Send(ws, msg)
+	}
+	keepAlive := func() error {
+		return websocket.Message.Send(ws, "{}")
+	}
+	if err := s.follow(st, done, send, keepAlive); err != nil {
+		log.Println("pushstream: websocket:", err)
+	}
+}
@@ -2,6 +2,7 @@ package pushstream
 
 import (
 	"crypto/sha1"
+	"crypto/subtle"
 	"fmt"
 )
 
@@ -11,14 +12,25 @@ type PushNotification struct {
 	Size      int64  `json:"size"`
 	Signature string `json:"sig"`
 	CacheKey  string `json:"cacheKey"`
+
+	// Expire and KeyID are set for signatures made by Keys. Expire is in
+	// unix seconds.
+	Expire int64  `json:"exp,omitempty"`
+	KeyID  string `json:"kid,omitempty"`
+
+	// memo is set by Broker.Publish, sharing renders among subscribers.
+	memo *renderMemo
 }
 
+// Sign signs with the legacy scheme. Use Keys.Sign instead.
 func (p *PushNotification) Sign(secret string) {
 	p.Signature = p.calcSig(secret)
 }
 
+// CheckSignature checks signature of the legacy scheme. Use Keys.Verify
+// instead.
 func (p *PushNotification) CheckSignature(secret string) bool {
-	return p.Signature == p.calcSig(secret)
+	return subtle.ConstantTimeCompare([]byte(p.Signature), []byte(p.calcSig(secret))) == 1
 }
 
 func (p *PushNotification) calcSig(secret string) string {
@@ -29,6 +41,12 @@ func GetPushChannel(p *PushNotification, secret string) string {
 	return sha1hex(fmt.Sprintf("%v/%v/%v", p.Brdname, p.Filename, secret))
 }
 
+// GetBoardPushChannel returns the channel of new posts of a board. It shares
+// the scheme of GetPushChannel with an empty filename.
+func GetBoardPushChannel(brdname, secret string) string {
+	return GetPushChannel(&PushNotification{Brdname: brdname}, secret)
+}
+
 func sha1hex(s string) string {
 	return fmt.Sprintf("%x", sha1.Sum([]byte(s)))
 }
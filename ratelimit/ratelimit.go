@@ -0,0 +1,223 @@
+// Package ratelimit limits request rates of clients with token buckets.
+package ratelimit
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+const (
+	DefaultMaxKeys    = 100000
+	DefaultExemptSecs = 60 * 60
+	DefaultPrefix     = "pttweb:ratelimit:"
+)
+
+// Budget is the rate and burst of requests allowed.
+type Budget struct {
+	// Rate is the number of requests allowed per second on average.
+	Rate float64
+	// Burst is the number of requests allowed at once.
+	Burst int
+}
+
+func (b Budget) unlimited() bool {
+	return b.Rate <= 0 || b.Burst <= 0
+}
+
+type Config struct {
+	Enabled bool
+
+	// TrustedProxies are IPs or CIDRs of proxies in front of us. Their
+	// X-Forwarded-For headers are honored to find the client IP.
+	TrustedProxies []string
+
+	// Default is the budget of routes not in Routes. Routes are keyed by
+	// route names. Each route has its own buckets. Zero budgets are
+	// unlimited.
+	Default Budget
+	Routes  map[string]Budget
+
+	// MaxKeys limits the number of buckets kept in memory. Redis, if set,
+	// keeps buckets instead, and Prefix prefixes its keys.
+	MaxKeys int
+	Redis   *RedisConfig
+	Prefix  string
+
+	// ExemptSecs is how long a client passing captcha is exempted.
+	ExemptSecs int
+}
+
+// See https://godoc.org/github.com/go-redis/redis#Options
+type RedisConfig struct {
+	Network  string
+	Addr     string
+	Password string
+	DB       int
+}
+
+// Limiter is a middleware limiting request rates by client IP.
+type Limiter struct {
+	cfg     Config
+	store   Store
+	proxies Proxies
+
+	// OnLimited, if set, handles limited requests instead of a plain 429.
+	OnLimited http.HandlerFunc
+}
+
+func New(cfg Config) (*Limiter, error) {
+	proxies, err := ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	l := &Limiter{cfg: cfg, proxies: proxies}
+
+	if cfg.Redis != nil {
+		prefix := cfg.Prefix
+		if prefix == "" {
+			prefix = DefaultPrefix
+		}
+		l.store = NewRedisStore(redis.NewClient(&redis.Options{
+			Network:  cfg.Redis.Network,
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		}), prefix)
+	} else {
+		maxKeys := cfg.MaxKeys
+		if maxKeys <= 0 {
+			maxKeys = DefaultMaxKeys
+		}
+		l.store = NewMemoryStore(maxKeys)
+	}
+	return l, nil
+}
+
+// Proxies are networks of trusted proxies in front of us.
+type Proxies []*net.IPNet
+
+// ParseProxies parses IPs or CIDRs of proxies, as in
+// Config.TrustedProxies.
+func ParseProxies(list []string) (Proxies, error) {
+	var proxies Proxies
+	for _, p := range list {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies, nil
+}
+
+func (p Proxies) isTrusted(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client. X-Forwarded-For is walked from the
+// nearest hop, as long as the hop is a trusted proxy.
+func (p Proxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.isTrusted(ip) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !p.isTrusted(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// ClientIP returns the IP of the client, see Proxies.ClientIP.
+func (l *Limiter) ClientIP(r *http.Request) string {
+	return l.proxies.ClientIP(r)
+}
+
+func (l *Limiter) budget(route string) Budget {
+	if b, ok := l.cfg.Routes[route]; ok {
+		return b
+	}
+	return l.cfg.Default
+}
+
+// Allow takes a token for the request. Errors of the store are logged and
+// the request is allowed.
+func (l *Limiter) Allow(r *http.Request) bool {
+	var route string
+	if cr := mux.CurrentRoute(r); cr != nil {
+		route = cr.GetName()
+	}
+	b := l.budget(route)
+	if b.unlimited() {
+		return true
+	}
+
+	ip := l.ClientIP(r)
+	now := time.Now()
+	ok, err := l.store.Take(route+"/"+ip, b, now)
+	if err == nil && !ok {
+		ok, err = l.store.IsExempt(ip, now)
+	}
+	if err != nil {
+		log.Println("ratelimit:", err)
+		return true
+	}
+	return ok
+}
+
+// Exempt exempts the client of the request from limits, e.g. after solving
+// a captcha.
+func (l *Limiter) Exempt(r *http.Request) error {
+	secs := l.cfg.ExemptSecs
+	if secs <= 0 {
+		secs = DefaultExemptSecs
+	}
+	return l.store.Exempt(l.ClientIP(r), time.Duration(secs)*time.Second, time.Now())
+}
+
+// Middleware returns a mux middleware. It needs to be used by a mux router
+// so route names are known.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Allow(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if l.OnLimited != nil {
+			l.OnLimited(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	})
+}
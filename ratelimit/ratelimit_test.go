@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(10)
+	b := Budget{Rate: 1, Burst: 2}
+	now := time.Unix(1700000000, 0)
+
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := s.Take("k", b, now); ok != want {
+			t.Errorf("take %v: got %v, want %v", i, ok, want)
+		}
+	}
+	if ok, _ := s.Take("other", b, now); !ok {
+		t.Error("buckets should be per key")
+	}
+	if ok, _ := s.Take("k", b, now.Add(time.Second)); !ok {
+		t.Error("bucket should be refilled")
+	}
+
+	s.Exempt("k", time.Minute, now)
+	if ok, _ := s.IsExempt("k", now.Add(time.Second)); !ok {
+		t.Error("expected exempted")
+	}
+	if ok, _ := s.IsExempt("k", now.Add(time.Minute)); ok {
+		t.Error("exemption should expire")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	l, err := New(Config{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remote string
+		xff    string
+		want   string
+	}{
+		{"1.2.3.4:1234", "", "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"10.1.2.3:1234", "5.6.7.8", "5.6.7.8"},
+		{"10.1.2.3:1234", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"10.1.2.3:1234", "garbage", "10.1.2.3"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := l.ClientIP(r); got != tc.want {
+			t.Errorf("ClientIP(%v, %q) = %v, want %v", tc.remote, tc.xff, got, tc.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, err := New(Config{
+		Routes: map[string]Budget{
+			"search": {Rate: 0.001, Burst: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.Path("/search").HandlerFunc(ok).Name("search")
+	r.Path("/article").HandlerFunc(ok).Name("article")
+	r.Use(l.Middleware)
+
+	get := func(path, remote string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("/search", "1.2.3.4:1"); code != http.StatusOK {
+		t.Error("first search:", code)
+	}
+	if code := get("/search", "1.2.3.4:1"); code != http.StatusTooManyRequests {
+		t.Error("second search:", code)
+	}
+	if code := get("/search", "5.6.7.8:1"); code != http.StatusOK {
+		t.Error("search of another client:", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := get("/article", "1.2.3.4:1"); code != http.StatusOK {
+			t.Error("unlimited route:", code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/search", nil)
+	req.RemoteAddr = "1.2.3.4:1"
+	l.Exempt(req)
+	if code := get("/search", "1.2.3.4:1"); code != http.StatusOK {
+		t.Error("search after exempted:", code)
+	}
+}
@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Store keeps token buckets.
+type Store interface {
+	// Take takes a token from the bucket of key, and returns false if the
+	// bucket is empty.
+	Take(key string, b Budget, now time.Time) (bool, error)
+
+	// Exempt marks key exempted from limits for d.
+	Exempt(key string, d time.Duration, now time.Time) error
+
+	// IsExempt returns whether key is exempted.
+	IsExempt(key string, now time.Time) (bool, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in memory. Least recently used buckets are
+// dropped when there are more than maxKeys of them, which only makes limits
+// more lenient.
+type MemoryStore struct {
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	exempts map[string]time.Time
+}
+
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	return &MemoryStore{
+		maxKeys: maxKeys,
+		buckets: make(map[string]*bucket),
+		exempts: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Take(key string, b Budget, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bk, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= s.maxKeys {
+			s.evict()
+		}
+		bk = &bucket{tokens: float64(b.Burst), last: now}
+		s.buckets[key] = bk
+	}
+	bk.tokens += now.Sub(bk.last).Seconds() * b.Rate
+	if bk.tokens > float64(b.Burst) {
+		bk.tokens = float64(b.Burst)
+	}
+	bk.last = now
+	if bk.tokens < 1 {
+		return false, nil
+	}
+	bk.tokens--
+	return true, nil
+}
+
+// evict drops the older half of buckets. It must be called with mu held.
+func (s *MemoryStore) evict() {
+	var sum int64
+	for _, bk := range s.buckets {
+		sum += bk.last.UnixNano() / int64(len(s.buckets))
+	}
+	for key, bk := range s.buckets {
+		if bk.last.UnixNano() <= sum {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *MemoryStore) Exempt(key string, d time.Duration, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.exempts) >= s.maxKeys {
+		for k, until := range s.exempts {
+			if !now.Before(until) {
+				delete(s.exempts, k)
+			}
+		}
+	}
+	s.exempts[key] = now.Add(d)
+	return nil
+}
+
+func (s *MemoryStore) IsExempt(key string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.exempts[key]
+	if ok && !now.Before(until) {
+		delete(s.exempts, key)
+		return false, nil
+	}
+	return ok, nil
+}
+
+// takeScript refills and takes from a bucket stored as a hash of tokens and
+// last refill time in seconds.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(b[1])
+local last = tonumber(b[2])
+if tokens == nil or last == nil then
+	tokens = burst
+	last = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+local ok = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	ok = 1
+end
+redis.call('HMSET', KEYS[1], 't', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return ok
+`)
+
+// RedisStore keeps buckets in Redis, so that limits are shared by instances.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *RedisStore) Take(key string, b Budget, now time.Time) (bool, error) {
+	keys := []string{s.prefix + "b:" + key}
+	ts := strconv.FormatFloat(float64(now.UnixNano())/1e9, 'f', 3, 64)
+	n, err := takeScript.Run(s.client, keys, b.Rate, b.Burst, ts).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *RedisStore) Exempt(key string, d time.Duration, now time.Time) error {
+	return s.client.Set(s.prefix+"x:"+key, 1, d).Err()
+}
+
+func (s *RedisStore) IsExempt(key string, now time.Time) (bool, error) {
+	n, err := s.client.Exists(s.prefix + "x:" + key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,244 @@
+package richcontent
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	oembedTimeout      = 3 * time.Second
+	oembedMaxBodySize  = 64 * 1024
+	oembedCacheTTL     = time.Hour
+	oembedErrorTTL     = time.Minute
+	oembedCacheEntries = 4096
+
+	// oembedMaxBackground limits lookups in the background. Lookups are
+	// dropped when it is reached, and tried again by later finds.
+	oembedMaxBackground = 16
+)
+
+var errOEmbedPending = errors.New("oembed: lookup pending")
+
+// OEmbedResponse is a response of an oEmbed endpoint.
+type OEmbedResponse struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	HTML         string `json:"html"`
+	URL          string `json:"url"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	ProviderName string `json:"provider_name"`
+}
+
+type oembedEntry struct {
+	key    string
+	res    *OEmbedResponse
+	err    error
+	expire time.Time
+}
+
+// oembedClient queries oEmbed endpoints, and remembers results for a while
+// as the same urls are usually rendered again soon. Failures are remembered
+// briefly, and the least recently used results are dropped when the cache
+// is full.
+type oembedClient struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	cache    map[string]*list.Element
+	lru      *list.List
+	inflight map[string]bool
+
+	// background holds a token for each lookup in the background.
+	background chan struct{}
+}
+
+func newOEmbedClient() *oembedClient {
+	return &oembedClient{
+		client:   &http.Client{Timeout: oembedTimeout},
+		cache:    make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]bool),
+
+		background: make(chan struct{}, oembedMaxBackground),
+	}
+}
+
+type lookupBudgetKey struct{}
+
+// WithLookupBudget allows up to n uncached lookups of remote embeds, e.g.
+// oEmbed, to be made while finding rich contents with the returned context.
+// Lookups beyond the budget are made in the background, as many as allowed
+// at a time, and are ready for later finds. Without a budget, all lookups
+// are made in the background.
+func WithLookupBudget(ctx context.Context, n int) context.Context {
+	budget := int32(n)
+	return context.WithValue(ctx, lookupBudgetKey{}, &budget)
+}
+
+func takeLookupBudget(ctx context.Context) bool {
+	budget, _ := ctx.Value(lookupBudgetKey{}).(*int32)
+	return budget != nil && atomic.AddInt32(budget, -1) >= 0
+}
+
+func (c *oembedClient) fetch(ctx context.Context, endpoint, u string) (*OEmbedResponse, error) {
+	key := endpoint + "\x00" + u
+
+	c.mu.Lock()
+	if el, ok := c.cache[key]; ok {
+		e := el.Value.(*oembedEntry)
+		if time.Now().Before(e.expire) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return e.res, e.err
+		}
+	}
+	if c.inflight[key] {
+		c.mu.Unlock()
+		return nil, errOEmbedPending
+	}
+	c.inflight[key] = true
+	c.mu.Unlock()
+
+	if !takeLookupBudget(ctx) {
+		select {
+		case c.background <- struct{}{}:
+		default:
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+			return nil, errOEmbedPending
+		}
+		go func() {
+			defer func() { <-c.background }()
+			c.lookup(context.Background(), key, endpoint, u)
+		}()
+		return nil, errOEmbedPending
+	}
+	return c.lookup(ctx, key, endpoint, u)
+}
+
+func (c *oembedClient) lookup(ctx context.Context, key, endpoint, u string) (*OEmbedResponse, error) {
+	res, err := c.query(ctx, endpoint, u)
+
+	ttl := oembedCacheTTL
+	if err != nil {
+		ttl = oembedErrorTTL
+	}
+	e := &oembedEntry{key: key, res: res, err: err, expire: time.Now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, key)
+	if el, ok := c.cache[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return res, err
+	}
+	c.cache[key] = c.lru.PushFront(e)
+	for c.lru.Len() > oembedCacheEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.cache, el.Value.(*oembedEntry).key)
+	}
+	return res, err
+}
+
+func (c *oembedClient) query(ctx context.Context, endpoint, u string) (*OEmbedResponse, error) {
+	q := make(url.Values)
+	q.Set("url", u)
+	q.Set("format", "json")
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequest("GET", endpoint+sep+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oembed: %v: %v", endpoint, resp.Status)
+	}
+	var res OEmbedResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oembedMaxBodySize)).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// oembedProvider is an entry of the provider list of oEmbed.
+type oembedProvider struct {
+	ProviderName string `json:"provider_name"`
+	Endpoints    []struct {
+		Schemes []string `json:"schemes"`
+		URL     string   `json:"url"`
+	} `json:"endpoints"`
+}
+
+// LoadOEmbedProviders reads a provider list in the format of
+// https://oembed.com/providers.json, and returns providers of endpoints
+// having url schemes.
+func LoadOEmbedProviders(r io.Reader) ([]ProviderConfig, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var list []oembedProvider
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	var cfgs []ProviderConfig
+	for _, p := range list {
+		for _, ep := range p.Endpoints {
+			if len(ep.Schemes) == 0 || ep.URL == "" {
+				continue
+			}
+			cfgs = append(cfgs, ProviderConfig{
+				Name:           p.ProviderName,
+				Pattern:        schemesPattern(ep.Schemes),
+				OEmbedEndpoint: strings.Replace(ep.URL, "{format}", "json", -1),
+			})
+		}
+	}
+	if len(list) > 0 && len(cfgs) == 0 {
+		return nil, errors.New("no oembed endpoints with schemes")
+	}
+	return cfgs, nil
+}
+
+// schemesPattern converts url schemes of oEmbed, where * is a wildcard, into
+// a regexp. Wildcards in hosts match within the host only, so that e.g.
+// https://*.example.com/* doesn't match https://evil.test/.example.com/.
+func schemesPattern(schemes []string) string {
+	alts := make([]string, len(schemes))
+	for i, s := range schemes {
+		host, path := s, ""
+		if j := strings.Index(s, "://"); j >= 0 {
+			if k := strings.Index(s[j+3:], "/"); k >= 0 {
+				host, path = s[:j+3+k], s[j+3+k:]
+			}
+		}
+		alts[i] = strings.Replace(regexp.QuoteMeta(host), `\*`, `[^/?#]*`, -1) +
+			strings.Replace(regexp.QuoteMeta(path), `\*`, `.*`, -1)
+	}
+	return `^(?:` + strings.Join(alts, `|`) + `)$`
+}
@@ -0,0 +1,262 @@
+package richcontent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/ptt/pttweb/extcache"
+	"golang.org/x/net/context"
+	xhtml "golang.org/x/net/html"
+)
+
+// ProviderConfig declares an embed provider. Urls matching Pattern are
+// embedded by executing Template, or else from the oEmbed endpoint.
+type ProviderConfig struct {
+	Name string
+
+	// Pattern is a regexp matched against urls.
+	Pattern string
+
+	// Template is a html/template of the embed, executed with EmbedData.
+	Template string
+
+	// SrcTemplate is a text/template making EmbedData.Src from EmbedData,
+	// e.g. the image of the url. Src defaults to the url itself.
+	SrcTemplate string
+
+	// ExtCache proxies Src, or images of oEmbed, through extcache. Nothing
+	// is embedded without extcache.
+	ExtCache bool
+
+	// OEmbedEndpoint, if set, is queried for the embed. Template is
+	// optional then, and is given the response in EmbedData.OEmbed.
+	OEmbedEndpoint string
+
+	// Trusted embeds the html of oEmbed responses as is. Otherwise only
+	// the iframe or image of the response is embedded, if it is loaded
+	// from AllowedHosts.
+	Trusted bool
+
+	// AllowedHosts, and their subdomains, are where untrusted oEmbed
+	// embeds may be loaded from. They default to the hosts of the endpoint
+	// and of the url.
+	AllowedHosts []string
+}
+
+// EmbedData is given to templates of providers.
+type EmbedData struct {
+	// URL is the matched url.
+	URL string
+	// Match is the submatches of the pattern, Match[0] being the url.
+	Match []string
+	// Src is made by SrcTemplate, proxied if ExtCache is set.
+	Src string
+	// OEmbed is the response of the oEmbed endpoint, if any.
+	OEmbed *OEmbedResponse
+}
+
+type provider struct {
+	cfg     ProviderConfig
+	tmpl    *template.Template
+	srcTmpl *texttemplate.Template
+	oembed  *oembedClient
+}
+
+func newProvider(cfg ProviderConfig, oembed *oembedClient) (*UrlPattern, error) {
+	if cfg.Pattern == "" {
+		return nil, errors.New("pattern not specified")
+	}
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &provider{cfg: cfg}
+	if cfg.Template == "" && cfg.OEmbedEndpoint == "" {
+		return nil, errors.New("either template or oembed endpoint is required")
+	}
+	if cfg.Template != "" {
+		if p.tmpl, err = template.New(cfg.Name).Parse(cfg.Template); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SrcTemplate != "" {
+		if p.srcTmpl, err = texttemplate.New(cfg.Name).Parse(cfg.SrcTemplate); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.OEmbedEndpoint != "" {
+		p.oembed = oembed
+	}
+	return &UrlPattern{
+		Pattern: re,
+		Handler: p.handle,
+	}, nil
+}
+
+func (p *provider) handle(ctx context.Context, urlBytes []byte, match MatchIndices) ([]Component, error) {
+	data := &EmbedData{
+		URL:   string(urlBytes),
+		Match: make([]string, match.Len()),
+	}
+	for i := range data.Match {
+		if b, _ := match.At(i); b >= 0 {
+			data.Match[i] = string(match.ByteSliceOf(urlBytes, i))
+		}
+	}
+
+	data.Src = data.URL
+	if p.srcTmpl != nil {
+		var buf bytes.Buffer
+		if err := p.srcTmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		data.Src = buf.String()
+	}
+
+	if p.oembed != nil {
+		res, err := p.oembed.fetch(ctx, p.cfg.OEmbedEndpoint, data.URL)
+		if err != nil {
+			return nil, err
+		}
+		data.OEmbed = res
+		if res.Type == "photo" {
+			if !p.cfg.Trusted && !p.allowedSrc(res.URL, data.URL) {
+				return nil, nil
+			}
+			data.Src = res.URL
+		}
+	}
+
+	if p.cfg.ExtCache {
+		cache, _ := extcache.FromContext(ctx)
+		if cache == nil {
+			return nil, nil
+		}
+		src, err := cache.Generate(data.Src)
+		if err != nil {
+			return nil, nil // Silently ignore
+		}
+		data.Src = src
+	}
+
+	if p.tmpl == nil {
+		return p.oembedComponents(data)
+	}
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return []Component{MakeComponent(buf.String())}, nil
+}
+
+// oembedComponents embeds the oEmbed response, as is only if the provider is
+// trusted.
+func (p *provider) oembedComponents(data *EmbedData) ([]Component, error) {
+	switch data.OEmbed.Type {
+	case "photo":
+		return []Component{MakeComponent(imageHtmlTag(data.Src))}, nil
+	case "video", "rich":
+		if data.OEmbed.HTML == "" {
+			return nil, nil
+		}
+		if p.cfg.Trusted {
+			return []Component{MakeComponent(fmt.Sprintf(
+				`<div class="resize-container"><div class="resize-content">%s</div></div>`,
+				data.OEmbed.HTML))}, nil
+		}
+		src := iframeSrc(data.OEmbed.HTML)
+		if src == "" || !p.allowedSrc(src, data.URL) {
+			return nil, nil
+		}
+		return []Component{MakeComponent(fmt.Sprintf(
+			`<div class="resize-container"><div class="resize-content"><iframe src="%s" frameborder="0" sandbox="allow-scripts allow-same-origin allow-popups" allowfullscreen></iframe></div></div>`,
+			html.EscapeString(src)))}, nil
+	}
+	return nil, nil
+}
+
+// iframeSrc returns the src of the first iframe in an html fragment.
+func iframeSrc(fragment string) string {
+	z := xhtml.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			t := z.Token()
+			if t.Data != "iframe" {
+				continue
+			}
+			for _, a := range t.Attr {
+				if a.Key == "src" {
+					return a.Val
+				}
+			}
+			return ""
+		}
+	}
+}
+
+// allowedSrc tells if an untrusted embed may be loaded from src, for the
+// url being embedded.
+func (p *provider) allowedSrc(src, pageURL string) bool {
+	u, err := url.Parse(src)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "":
+	default:
+		return false
+	}
+	hosts := p.cfg.AllowedHosts
+	if len(hosts) == 0 {
+		for _, s := range []string{p.cfg.OEmbedEndpoint, pageURL} {
+			if v, err := url.Parse(s); err == nil && v.Hostname() != "" {
+				hosts = append(hosts, strings.TrimPrefix(v.Hostname(), "www."))
+			}
+		}
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetProviders makes urls matching configured providers embedded before the
+// builtin ones, and those of oEmbed providers listed in oembedProviders
+// after them. oembedProviders may be nil. It is meant to be called at
+// startup.
+func SetProviders(cfgs []ProviderConfig, oembedProviders []ProviderConfig) error {
+	oembed := newOEmbedClient()
+	var configured, listed []*UrlPattern
+	for _, l := range []struct {
+		cfgs     []ProviderConfig
+		patterns *[]*UrlPattern
+	}{
+		{cfgs, &configured},
+		{oembedProviders, &listed},
+	} {
+		for _, cfg := range l.cfgs {
+			p, err := newProvider(cfg, oembed)
+			if err != nil {
+				return fmt.Errorf("embed provider %q: %v", cfg.Name, err)
+			}
+			*l.patterns = append(*l.patterns, p)
+		}
+	}
+	patterns := append(configured, defaultUrlPatterns...)
+	urlPatterns = append(patterns, listed...)
+	return nil
+}
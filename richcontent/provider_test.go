@@ -0,0 +1,270 @@
+package richcontent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ptt/pttweb/extcache"
+	"golang.org/x/net/context"
+)
+
+type fakeExtCache struct{}
+
+func (fakeExtCache) Generate(urlStr string) (string, error) {
+	return "https://cache.example/" + strings.TrimPrefix(urlStr, "https://"), nil
+}
+
+func findHTML(t *testing.T, ctx context.Context, input string) string {
+	rcs, err := FindUrl(ctx, []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var htmls []string
+	for _, rc := range rcs {
+		for _, c := range rc.Components() {
+			htmls = append(htmls, c.HTML())
+		}
+	}
+	return strings.Join(htmls, "\n")
+}
+
+func TestSetProviders(t *testing.T) {
+	defer func() { urlPatterns = defaultUrlPatterns }()
+
+	if err := SetProviders([]ProviderConfig{
+		{
+			Name:     "video",
+			Pattern:  `^https://video\.example/v/(\w+)$`,
+			Template: `<iframe src="https://video.example/embed/{{index .Match 1}}"></iframe>`,
+		},
+		{
+			Name:        "pics",
+			Pattern:     `^https://pics\.example/(\w+)$`,
+			SrcTemplate: `https://pics.example/raw/{{index .Match 1}}.jpg`,
+			Template:    `<img src="{{.Src}}">`,
+			ExtCache:    true,
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if got, want := findHTML(t, ctx, "https://video.example/v/abc"), `<iframe src="https://video.example/embed/abc"></iframe>`; got != want {
+		t.Errorf("video embed = %q; want %q", got, want)
+	}
+	// Nothing without extcache.
+	if got := findHTML(t, ctx, "https://pics.example/abc"); got != "" {
+		t.Errorf("pics embed without extcache = %q; want empty", got)
+	}
+	ctx = extcache.WithExtCache(ctx, fakeExtCache{})
+	if got, want := findHTML(t, ctx, "https://pics.example/abc"), `<img src="https://cache.example/pics.example/raw/abc.jpg">`; got != want {
+		t.Errorf("pics embed = %q; want %q", got, want)
+	}
+	// Builtin ones still work.
+	if got := findHTML(t, ctx, "https://example.com/a.png"); !strings.Contains(got, "<img") {
+		t.Errorf("generic image embed = %q", got)
+	}
+}
+
+func TestSetProvidersInvalid(t *testing.T) {
+	defer func() { urlPatterns = defaultUrlPatterns }()
+
+	for _, cfg := range []ProviderConfig{
+		{Name: "no pattern", Template: "x"},
+		{Name: "bad pattern", Pattern: "(", Template: "x"},
+		{Name: "no template", Pattern: "x"},
+		{Name: "bad template", Pattern: "x", Template: "{{"},
+	} {
+		if err := SetProviders([]ProviderConfig{cfg}, nil); err == nil {
+			t.Errorf("%v: SetProviders() = nil; want error", cfg.Name)
+		}
+	}
+}
+
+func TestOEmbedProviders(t *testing.T) {
+	defer func() { urlPatterns = defaultUrlPatterns }()
+
+	var queries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		switch u := r.FormValue("url"); {
+		case strings.Contains(u, "/photo/"):
+			fmt.Fprint(w, `{"type": "photo", "url": "https://media.example/p.jpg"}`)
+		case strings.Contains(u, "/evil/"):
+			fmt.Fprint(w, `{"type": "video", "html": "<script>alert(1)</script><iframe src=\"https://evil.test/x\"></iframe>"}`)
+		default:
+			fmt.Fprint(w, `{"type": "video", "html": "<script>alert(1)</script><iframe src=\"https://player.media.example/embed?a=1&amp;b=2\"></iframe>"}`)
+		}
+	}))
+	defer srv.Close()
+
+	list := fmt.Sprintf(`[{
+		"provider_name": "Media",
+		"endpoints": [{"schemes": ["https://media.example/*"], "url": "%v/oembed.{format}"}]
+	}, {
+		"provider_name": "No schemes",
+		"endpoints": [{"url": "https://other.example/oembed"}]
+	}]`, srv.URL)
+	providers, err := LoadOEmbedProviders(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 1 || providers[0].OEmbedEndpoint != srv.URL+"/oembed.json" {
+		t.Fatalf("LoadOEmbedProviders() = %+v", providers)
+	}
+	if err := SetProviders(nil, providers); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithLookupBudget(context.Background(), 10)
+	if got := findHTML(t, ctx, "https://media.example/photo/1"); !strings.Contains(got, `<img src="https://media.example/p.jpg"`) {
+		t.Errorf("photo embed = %q", got)
+	}
+	got := findHTML(t, ctx, "https://media.example/video/1")
+	if !strings.Contains(got, `<iframe src="https://player.media.example/embed?a=1&amp;b=2"`) || strings.Contains(got, "<script") {
+		t.Errorf("video embed = %q", got)
+	}
+	if got := findHTML(t, ctx, "https://media.example/evil/1"); got != "" {
+		t.Errorf("embed from another host = %q; want empty", got)
+	}
+	findHTML(t, ctx, "https://media.example/video/1")
+	if n := atomic.LoadInt32(&queries); n != 3 {
+		t.Errorf("queries = %v; want 3 as results are cached", n)
+	}
+
+	// Builtin patterns take precedence.
+	if got := findHTML(t, ctx, "https://media.example/a.png"); !strings.Contains(got, `<img src="https://media.example/a.png"`) {
+		t.Errorf("image embed = %q", got)
+	}
+}
+
+func TestOEmbedTrusted(t *testing.T) {
+	defer func() { urlPatterns = defaultUrlPatterns }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type": "rich", "html": "<blockquote>post</blockquote>"}`)
+	}))
+	defer srv.Close()
+
+	if err := SetProviders([]ProviderConfig{{
+		Name:           "trusted",
+		Pattern:        `^https://trusted\.example/`,
+		OEmbedEndpoint: srv.URL,
+		Trusted:        true,
+	}, {
+		Name:           "untrusted",
+		Pattern:        `^https://untrusted\.example/`,
+		OEmbedEndpoint: srv.URL,
+	}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithLookupBudget(context.Background(), 10)
+	if got := findHTML(t, ctx, "https://trusted.example/1"); !strings.Contains(got, "<blockquote>post</blockquote>") {
+		t.Errorf("trusted embed = %q", got)
+	}
+	if got := findHTML(t, ctx, "https://untrusted.example/1"); got != "" {
+		t.Errorf("untrusted embed = %q; want empty", got)
+	}
+}
+
+func TestOEmbedLookupBudget(t *testing.T) {
+	queried := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type": "photo", "url": "https://media.example/p.jpg"}`)
+		queried <- struct{}{}
+	}))
+	defer srv.Close()
+
+	c := newOEmbedClient()
+	ctx := context.Background()
+	if _, err := c.fetch(ctx, srv.URL, "https://media.example/1"); err != errOEmbedPending {
+		t.Fatalf("fetch() without budget = %v; want pending", err)
+	}
+	<-queried
+	for i := 0; ; i++ {
+		res, err := c.fetch(ctx, srv.URL, "https://media.example/1")
+		if err == nil {
+			if res.URL != "https://media.example/p.jpg" {
+				t.Errorf("fetch() = %+v", res)
+			}
+			break
+		}
+		if err != errOEmbedPending || i > 100 {
+			t.Fatalf("fetch() after lookup = %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx = WithLookupBudget(ctx, 1)
+	if _, err := c.fetch(ctx, srv.URL, "https://media.example/2"); err != nil {
+		t.Errorf("fetch() within budget = %v", err)
+	}
+	<-queried
+	if _, err := c.fetch(ctx, srv.URL, "https://media.example/3"); err != errOEmbedPending {
+		t.Errorf("fetch() over budget = %v; want pending", err)
+	}
+	<-queried
+}
+
+func TestOEmbedBackgroundLimit(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		fmt.Fprint(w, `{"type": "photo", "url": "https://media.example/p.jpg"}`)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := newOEmbedClient()
+	ctx := context.Background()
+	for i := 0; i < 2*oembedMaxBackground; i++ {
+		if _, err := c.fetch(ctx, srv.URL, fmt.Sprintf("https://media.example/%d", i)); err != errOEmbedPending {
+			t.Fatalf("fetch() = %v; want pending", err)
+		}
+	}
+	for i := 0; atomic.LoadInt32(&hits) < oembedMaxBackground; i++ {
+		if i > 100 {
+			t.Fatalf("%d lookups made; want %d", atomic.LoadInt32(&hits), oembedMaxBackground)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&hits); n != oembedMaxBackground {
+		t.Errorf("%d lookups made; want %d", n, oembedMaxBackground)
+	}
+
+	// Dropped lookups are not left in flight.
+	c.mu.Lock()
+	n := len(c.inflight)
+	c.mu.Unlock()
+	if n != oembedMaxBackground {
+		t.Errorf("%d lookups in flight; want %d", n, oembedMaxBackground)
+	}
+}
+
+func TestSchemesPattern(t *testing.T) {
+	re := regexp.MustCompile(schemesPattern([]string{"https://*.example.com/v/*"}))
+	for _, tc := range []struct {
+		url   string
+		match bool
+	}{
+		{"https://www.example.com/v/1", true},
+		{"https://a.b.example.com/v/1/2", true},
+		{"https://evil.test/.example.com/v/1", false},
+		{"https://evil.test?.example.com/v/1", false},
+		{"https://www.example.com/w/1", false},
+	} {
+		if got := re.MatchString(tc.url); got != tc.match {
+			t.Errorf("match %q = %v; want %v", tc.url, got, tc.match)
+		}
+	}
+}
@@ -15,7 +15,7 @@ func FindUrl(ctx context.Context, input []byte) ([]RichContent, error) {
 	for _, u := range FindAllUrlsIndex(input) {
 		urlBytes := input[u[0]:u[1]]
 		var components []Component
-		for _, p := range defaultUrlPatterns {
+		for _, p := range urlPatterns {
 			if match := p.Pattern.FindSubmatchIndex(urlBytes); match != nil {
 				if c, err := p.Handler(ctx, urlBytes, MatchIndices(match)); err == nil {
 					components = c
@@ -42,6 +42,9 @@ var defaultUrlPatterns = []*UrlPattern{
 	newUrlPattern(`\.(?i:png|jpeg|jpg|gif)$`, handleGenericImage),
 }
 
+// urlPatterns are the patterns in use, see SetProviders.
+var urlPatterns = defaultUrlPatterns
+
 func newUrlPattern(pattern string, handler UrlPatternHandler) *UrlPattern {
 	return &UrlPattern{
 		Pattern: regexp.MustCompile(pattern),
@@ -3,19 +3,23 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"time"
 
-	"golang.org/x/tools/blog/atom"
-
+	"github.com/ptt/pttweb/article"
+	"github.com/ptt/pttweb/atomfeed"
 	"github.com/ptt/pttweb/cache"
 	"github.com/ptt/pttweb/page"
 )
 
 // Useful when calling |NewFromBytes|
 var (
-	ZeroArticle       *Article
-	ZeroArticlePart   *ArticlePart
-	ZeroBbsIndex      *BbsIndex
-	ZeroBoardAtomFeed *BoardAtomFeed
+	ZeroArticle     *Article
+	ZeroArticlePart *ArticlePart
+	ZeroBbsIndex    *BbsIndex
+	ZeroBoardFeed   *BoardFeed
+	ZeroUserProfile *UserProfile
+	ZeroManIndex    *ManIndex
+	ZeroManSearch   *ManSearch
 )
 
 func gobEncodeBytes(obj interface{}) ([]byte, error) {
@@ -45,10 +49,16 @@ type Article struct {
 	ContentTailHtml []byte
 	IsPartial       bool
 	IsTruncated     bool
+	PushStats       article.PushStats
 
 	CacheKey   string
 	NextOffset int
 
+	// The middle of truncated articles, from MiddleOffset to MiddleEnd, is
+	// left out.
+	MiddleOffset int
+	MiddleEnd    int
+
 	IsValid bool
 }
 
@@ -65,6 +75,10 @@ type ArticlePart struct {
 	CacheKey    string
 	NextOffset  int
 	IsValid     bool
+	// HasMore is set if the article goes beyond NextOffset already.
+	HasMore bool
+	// IsStale is set if the article has changed since CacheKey.
+	IsStale bool
 }
 
 func (_ *ArticlePart) NewFromBytes(data []byte) (cache.Cacheable, error) {
@@ -85,30 +99,95 @@ func (bi *BbsIndex) EncodeToBytes() ([]byte, error) {
 	return gobEncodeBytes(bi)
 }
 
-type BoardAtomFeed struct {
-	Feed    *atom.Feed
+type BoardFeed struct {
+	Feed    *atomfeed.Feed
 	IsValid bool
+
+	// IsPartial is set for group feeds missing boards due to errors.
+	IsPartial bool
+}
+
+func (bf *BoardFeed) CacheExpire(expire time.Duration) time.Duration {
+	if bf.IsPartial && expire > PartialResultCacheTimeout {
+		return PartialResultCacheTimeout
+	}
+	return expire
 }
 
-func (_ *BoardAtomFeed) NewFromBytes(data []byte) (cache.Cacheable, error) {
-	return gobDecodeCacheable(data, new(BoardAtomFeed))
+func (_ *BoardFeed) NewFromBytes(data []byte) (cache.Cacheable, error) {
+	return gobDecodeCacheable(data, new(BoardFeed))
 }
 
-func (bi *BoardAtomFeed) EncodeToBytes() ([]byte, error) {
+func (bi *BoardFeed) EncodeToBytes() ([]byte, error) {
 	return gobEncodeBytes(bi)
 }
 
+type UserProfile page.UserProfile
+
+func (_ *UserProfile) NewFromBytes(data []byte) (cache.Cacheable, error) {
+	return gobDecodeCacheable(data, new(UserProfile))
+}
+
+func (up *UserProfile) CacheExpire(expire time.Duration) time.Duration {
+	if up.IsPartial && expire > PartialResultCacheTimeout {
+		return PartialResultCacheTimeout
+	}
+	return expire
+}
+
+func (up *UserProfile) EncodeToBytes() ([]byte, error) {
+	return gobEncodeBytes(up)
+}
+
+type ManEntry struct {
+	Path  string
+	Title string
+	IsDir bool
+}
+
+type ManIndex struct {
+	Entries []ManEntry
+	IsValid bool
+}
+
+func (_ *ManIndex) NewFromBytes(data []byte) (cache.Cacheable, error) {
+	return gobDecodeCacheable(data, new(ManIndex))
+}
+
+func (mi *ManIndex) EncodeToBytes() ([]byte, error) {
+	return gobEncodeBytes(mi)
+}
+
+type ManSearch struct {
+	Results   []page.ManSearchResult
+	Truncated bool
+}
+
+func (_ *ManSearch) NewFromBytes(data []byte) (cache.Cacheable, error) {
+	return gobDecodeCacheable(data, new(ManSearch))
+}
+
+func (ms *ManSearch) EncodeToBytes() ([]byte, error) {
+	return gobEncodeBytes(ms)
+}
+
 func init() {
 	gob.Register(Article{})
 	gob.Register(ArticlePart{})
 	gob.Register(BbsIndex{})
-	gob.Register(BoardAtomFeed{})
+	gob.Register(BoardFeed{})
+	gob.Register(UserProfile{})
+	gob.Register(ManIndex{})
+	gob.Register(ManSearch{})
 
 	// Make sure they are |Cacheable|
 	checkCacheable(new(Article))
 	checkCacheable(new(ArticlePart))
 	checkCacheable(new(BbsIndex))
-	checkCacheable(new(BoardAtomFeed))
+	checkCacheable(new(BoardFeed))
+	checkCacheable(new(UserProfile))
+	checkCacheable(new(ManIndex))
+	checkCacheable(new(ManSearch))
 }
 
 func checkCacheable(c cache.Cacheable) {
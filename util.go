@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 
 	"github.com/ptt/pttweb/page"
@@ -30,6 +31,61 @@ func NewNotFoundError(err error) *NotFoundError {
 	}
 }
 
+// ServerBusyError is shown when a backend has too many calls waiting.
+// It renders with the error page template.
+type ServerBusyError struct {
+	Title       string
+	ContentHtml string
+	Backend     string
+}
+
+func (*ServerBusyError) TemplateName() string { return page.TnameError }
+
+func (e *ServerBusyError) Error() string {
+	return fmt.Sprintf("server too busy: %v", e.Backend)
+}
+
+func (e *ServerBusyError) WriteHeaders(w http.ResponseWriter) error {
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return nil
+}
+
+func NewServerBusyError(backend string) *ServerBusyError {
+	return &ServerBusyError{
+		Title:       `503 - Server Too Busy`,
+		ContentHtml: `503 - Server Too Busy. 伺服器忙碌中，請稍後再試。`,
+		Backend:     backend,
+	}
+}
+
+// ManUnavailableError is shown when mand is down or not responding.
+type ManUnavailableError struct {
+	Title       string
+	ContentHtml string
+	err         error
+}
+
+func (*ManUnavailableError) TemplateName() string { return page.TnameError }
+
+func (e *ManUnavailableError) Error() string {
+	return fmt.Sprintf("man unavailable: %v", e.err)
+}
+
+func (e *ManUnavailableError) WriteHeaders(w http.ResponseWriter) error {
+	w.Header().Set("Retry-After", "30")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return nil
+}
+
+func NewManUnavailableError(err error) *ManUnavailableError {
+	return &ManUnavailableError{
+		Title:       `503 - 精華區暫時無法使用`,
+		ContentHtml: `503 - 精華區暫時無法使用，請稍後再試。`,
+		err:         err,
+	}
+}
+
 func isSafeRedirectURI(uri string) bool {
 	if len(uri) < 1 || uri[0] != '/' {
 		return false